@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// auditQueueSize bounds the decisions waiting to be written. Decisions
+	// are dropped rather than delaying admission when the sink falls behind.
+	auditQueueSize = 1024
+	// auditBatchSize bounds the decisions written to the sink at once
+	auditBatchSize = 100
+	// auditHTTPTimeout bounds a single request to an HTTP sink
+	auditHTTPTimeout = 10 * time.Second
+)
+
+// auditRecordType tells decisions apart from the log lines sharing stdout
+const auditRecordType = "audit"
+
+// decision is the audit record of an answered admission request
+type decision struct {
+	// Type is always "audit"
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Endpoint  string    `json:"endpoint"`
+	UID       types.UID `json:"uid"`
+	User      string    `json:"user"`
+	Operation string    `json:"operation"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	DryRun    bool      `json:"dryRun"`
+	Allowed   bool      `json:"allowed"`
+	// Reason is the message of a denial
+	Reason string `json:"reason,omitempty"`
+	// Rules are the names of the rules that changed the object. Inherit rules
+	// are named by their index, e.g. rules[2].
+	Rules []string `json:"rules,omitempty"`
+	// Patch is the JSON patch applied to the object
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// Violations are the required label violations by enforcement mode
+	Violations map[string][]string `json:"violations,omitempty"`
+	Exempted   []string            `json:"exempted,omitempty"`
+	Warnings   []string            `json:"warnings,omitempty"`
+	// LatencySeconds is the time taken to decide
+	LatencySeconds float64 `json:"latencySeconds"`
+	ConfigRevision string  `json:"configRevision"`
+}
+
+// newDecision records the response to request for obj, answered by the
+// handler of r since start
+func newDecision(r *http.Request, start time.Time, request *admissionv1.AdmissionRequest, obj *unstructured.Unstructured, response *admissionv1.AdmissionResponse, revision string) *decision {
+	record := &decision{
+		Type:           auditRecordType,
+		Time:           time.Now().UTC(),
+		Endpoint:       r.URL.Path,
+		UID:            request.UID,
+		User:           request.UserInfo.Username,
+		Operation:      string(request.Operation),
+		Kind:           request.Kind.Kind,
+		Namespace:      request.Namespace,
+		Name:           obj.GetName(),
+		DryRun:         isDryRun(request),
+		Allowed:        response.Allowed,
+		Patch:          response.Patch,
+		Warnings:       response.Warnings,
+		LatencySeconds: time.Since(start).Seconds(),
+		ConfigRevision: revision,
+	}
+	if record.Name == "" {
+		record.Name = obj.GetGenerateName()
+	}
+	if !response.Allowed && response.Result != nil {
+		record.Reason = response.Result.Message
+	}
+	return record
+}
+
+// addMutations records the rules that changed the object
+func (d *decision) addMutations(changes *mutations) {
+	d.Rules = changes.changedRules()
+	d.Exempted = changes.Exempted
+}
+
+// addViolations records the required labels the object violates
+func (d *decision) addViolations(violations map[string]field.ErrorList, exempted []string) {
+	for mode, errs := range violations {
+		if d.Violations == nil {
+			d.Violations = map[string][]string{}
+		}
+		for _, err := range errs {
+			d.Violations[mode] = append(d.Violations[mode], err.Error())
+		}
+	}
+	d.Exempted = exempted
+}
+
+// auditSink stores batches of decisions, one JSON document per line
+type auditSink interface {
+	write(lines [][]byte) error
+	close() error
+}
+
+// newAuditSink creates the sink described by spec: "stdout", an http:// or
+// https:// URL, or the path of a local file, optionally prefixed by file://
+func newAuditSink(spec string, maxSize int64, maxBackups int) (auditSink, error) {
+	switch {
+	case spec == "stdout":
+		return &writerSink{writer: os.Stdout}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &httpSink{url: spec, client: &http.Client{Timeout: auditHTTPTimeout}}, nil
+	default:
+		return newFileSink(strings.TrimPrefix(spec, "file://"), maxSize, maxBackups)
+	}
+}
+
+// writerSink writes decisions to a stream such as stdout
+type writerSink struct {
+	writer io.Writer
+}
+
+func (s *writerSink) write(lines [][]byte) error {
+	_, err := s.writer.Write(bytes.Join(lines, nil))
+	return err
+}
+
+func (s *writerSink) close() error {
+	return nil
+}
+
+// fileSink appends decisions to a local file. Once the file would exceed
+// maxSize it is rotated to path.1, path.1 to path.2 and so on, keeping
+// maxBackups rotated files.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+	sink := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit file: %v", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) write(lines [][]byte) error {
+	for _, line := range lines {
+		if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write audit file: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file: %v", err)
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit file: %v", err)
+		}
+	}
+	if s.maxBackups > 0 {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %v", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to rotate audit file: %v", err)
+	}
+	return s.open()
+}
+
+func (s *fileSink) close() error {
+	return s.file.Close()
+}
+
+// httpSink posts batches of decisions as newline delimited JSON to url
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) write(lines [][]byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return fmt.Errorf("failed to post decisions: %v", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("failed to post decisions: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() error {
+	return nil
+}
+
+// decisionLog writes decisions to a sink in the background. A nil
+// decisionLog discards them.
+type decisionLog struct {
+	sink  auditSink
+	queue chan *decision
+	done  chan struct{}
+
+	// mu guards closed, so that no decision is queued once queue is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newDecisionLog(sink auditSink) *decisionLog {
+	l := &decisionLog{
+		sink:  sink,
+		queue: make(chan *decision, auditQueueSize),
+		done:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// record queues a decision, dropping it when the queue is full
+func (l *decisionLog) record(d *decision) {
+	if l == nil {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		auditRecordsDropped.WithLabelValues("closed").Inc()
+		return
+	}
+	select {
+	case l.queue <- d:
+	default:
+		auditRecordsDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+func (l *decisionLog) run() {
+	defer close(l.done)
+	for d := range l.queue {
+		batch := []*decision{d}
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case d, ok := <-l.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, d)
+			default:
+				break drain
+			}
+		}
+		l.write(batch)
+	}
+}
+
+func (l *decisionLog) write(batch []*decision) {
+	lines := make([][]byte, 0, len(batch))
+	for _, d := range batch {
+		line, err := json.Marshal(d)
+		if err != nil {
+			log.WithError(err).WithField("uid", d.UID).Error("Failed to encode decision")
+			auditRecordsDropped.WithLabelValues("encode_failed").Inc()
+			continue
+		}
+		lines = append(lines, append(line, '\n'))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if err := l.sink.write(lines); err != nil {
+		log.WithError(err).WithField("decisions", len(lines)).Error("Failed to write decisions")
+		auditRecordsDropped.WithLabelValues("write_failed").Add(float64(len(lines)))
+		return
+	}
+	auditRecordsWritten.Add(float64(len(lines)))
+}
+
+// close writes the queued decisions and closes the sink, or gives up when
+// ctx is done. Decisions recorded afterwards are dropped.
+func (l *decisionLog) close(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to flush decisions: %v", ctx.Err())
+	}
+	return l.sink.close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddMutations(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{name: "new label", rules: "  - name: team\n    value: a\n", want: []string{"team"}},
+		{name: "unchanged value", rules: "  - name: app\n    value: web\n    override: true\n"},
+		{name: "existing key left alone", rules: "  - name: app\n    value: api\n"},
+		{name: "overridden value", rules: "  - name: app\n    value: api\n    override: true\n", want: []string{"app"}},
+		{name: "removed label", rules: "  - name: app\n    action: remove\n", want: []string{"app"}},
+		{name: "missing label to remove", rules: "  - name: team\n    action: remove\n"},
+		{name: "inherit rule", rules: "  - name: team\n    value: a\n  - inherit:\n      from: labels\n      keys: [cost-center]\n", want: []string{"rules[1]", "team"}},
+		{name: "rule not matching", rules: "  - name: team\n    value: a\n    match: object.metadata.name == 'db-0'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestNamespaces(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"cost-center": "42"}}})
+			config, err := parseConfig([]byte("rules:\n" + tt.rules))
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+			changes, _ := config.mutationsFor(newRuleContext(context.Background(), testPod(), testRequest("jane")))
+
+			record := &decision{}
+			record.addMutations(changes)
+			if !slices.Equal(record.Rules, tt.want) {
+				t.Errorf("Rules = %q, want %q", record.Rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecisionType(t *testing.T) {
+	request := testRequest("jane")
+	record := newDecision(httptest.NewRequest("POST", mutatePath, nil), time.Now(), request, testPod(), &admissionv1.AdmissionResponse{Allowed: true}, "rev")
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["type"] != auditRecordType {
+		t.Errorf("type = %v, want %q", fields["type"], auditRecordType)
+	}
+}
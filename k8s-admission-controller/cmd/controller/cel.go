@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+)
+
+// celCostLimit bounds the runtime cost of a single expression evaluation
+const celCostLimit = 1000000
+
+// celEnv declares the same variables as the in-tree admission policies, so
+// expressions can move between the webhook and native policies unchanged.
+// It uses the cel-go extension libraries rather than the Kubernetes ones of
+// k8s.io/apiserver/pkg/cel/library, see the README for the differences. The
+// environment is built on first use, and an error fails the compilation of
+// the configuration.
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		ext.Strings(),
+		ext.Lists(),
+		ext.Sets(),
+	)
+})
+
+// compileExpression type checks a CEL expression against the expected output
+// type and plans it for evaluation.
+func compileExpression(expression string, expected *cel.Type) (cel.Program, error) {
+	env, err := celEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %v", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	if output := ast.OutputType(); !output.IsExactType(expected) && !output.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression must evaluate to %s, got %s", expected, output)
+	}
+
+	return env.Program(ast, cel.CostLimit(celCostLimit))
+}
+
+// evalMatch evaluates a match condition for ctx
+func evalMatch(program cel.Program, ctx *ruleContext) (bool, error) {
+	result, err := evalExpression(program, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("match condition evaluated to %T, expected bool", result)
+	}
+	return matched, nil
+}
+
+// evalString evaluates a value expression for ctx
+func evalString(program cel.Program, ctx *ruleContext) (string, error) {
+	result, err := evalExpression(program, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := result.(string)
+	if !ok {
+		return "", fmt.Errorf("expression evaluated to %T, expected string", result)
+	}
+	return value, nil
+}
+
+func evalExpression(program cel.Program, ctx *ruleContext) (interface{}, error) {
+	data, err := ctx.variables()
+	if err != nil {
+		return nil, err
+	}
+
+	result, _, err := program.Eval(data)
+	if err != nil {
+		return nil, err
+	}
+	if types.IsError(result) {
+		return nil, fmt.Errorf("%v", result)
+	}
+	return result.Value(), nil
+}
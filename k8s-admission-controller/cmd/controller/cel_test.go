@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+)
+
+func TestCompileExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expected   *cel.Type
+		wantErr    string
+	}{
+		{name: "bool condition", expression: "object.metadata.namespace == 'team-a'", expected: cel.BoolType},
+		{name: "string value", expression: "object.metadata.name + '-x'", expected: cel.StringType},
+		{name: "dynamic value", expression: "object.metadata.labels['app']", expected: cel.StringType},
+		{name: "string extension", expression: "object.metadata.name.upperAscii()", expected: cel.StringType},
+		{name: "syntax error", expression: "object.metadata.name ==", expected: cel.BoolType, wantErr: "Syntax error"},
+		{name: "undeclared variable", expression: "pod.metadata.name", expected: cel.StringType, wantErr: "undeclared reference"},
+		{name: "wrong output type", expression: "'production'", expected: cel.BoolType, wantErr: "must evaluate to bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileExpression(tt.expression, tt.expected)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("compileExpression() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("compileExpression() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvalMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{name: "holds", expression: "object.metadata.namespace == 'team-a'", want: true},
+		{name: "does not hold", expression: "object.metadata.namespace == 'team-b'"},
+		{name: "request variable", expression: "request.operation == 'CREATE'", want: true},
+		{name: "old object is null on create", expression: "oldObject == null", want: true},
+		{name: "has macro", expression: "has(object.metadata.labels.app)", want: true},
+		{name: "list macro", expression: "object.spec.containers.exists(c, c.name == 'proxy')", want: true},
+		{name: "missing field fails", expression: "object.metadata.labels.team == 'a'", wantErr: true},
+		{name: "dynamic non-bool result fails", expression: "object.metadata.labels['app']", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compileExpression(tt.expression, cel.BoolType)
+			if err != nil {
+				t.Fatalf("compileExpression() error = %v", err)
+			}
+			got, err := evalMatch(program, newRuleContext(context.Background(), testPod(), testRequest("jane")))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evalMatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evalMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalString(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+		wantErr    bool
+	}{
+		{name: "field", expression: "object.metadata.name", want: "web-0"},
+		{name: "concatenation", expression: "object.metadata.namespace + '/' + object.metadata.name", want: "team-a/web-0"},
+		{name: "conditional", expression: "object.metadata.namespace.startsWith('team-') ? 'tenant' : 'system'", want: "tenant"},
+		{name: "string extension", expression: "object.spec.containers[1].image.split(':')[0]", want: "envoyproxy/envoy"},
+		{name: "missing field fails", expression: "object.metadata.labels.team", wantErr: true},
+		{name: "dynamic non-string result fails", expression: "object.spec.containers.size() > 0 ? object.metadata.labels : object.metadata.labels", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compileExpression(tt.expression, cel.StringType)
+			if err != nil {
+				t.Fatalf("compileExpression() error = %v", err)
+			}
+			got, err := evalString(program, newRuleContext(context.Background(), testPod(), testRequest("jane")))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evalString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evalString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want bool
+	}{
+		{name: "no condition", rule: "rules:\n  - name: team\n    value: a\n", want: true},
+		{name: "condition holds", rule: "rules:\n  - name: team\n    value: a\n    match: object.metadata.name.startsWith('web')\n", want: true},
+		{name: "condition does not hold", rule: "rules:\n  - name: team\n    value: a\n    match: object.metadata.name.startsWith('db')\n"},
+		{name: "failing condition skips the rule", rule: "rules:\n  - name: team\n    value: a\n    match: object.metadata.labels.team == 'a'\n"},
+		{name: "other kinds", rule: "rules:\n  - name: team\n    value: a\n    resources:\n      - group: apps\n        kind: Deployment\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.rule))
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+			if got := config.Rules[0].matches(newRuleContext(context.Background(), testPod(), testRequest("jane"))); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCELEnvError(t *testing.T) {
+	previous := celEnv
+	celEnv = func() (*cel.Env, error) { return nil, errors.New("overlapping function declaration") }
+	t.Cleanup(func() { celEnv = previous })
+
+	_, err := parseConfig([]byte("rules:\n  - name: team\n    match: \"true\"\n    default: platform\n"))
+	if err == nil || !strings.Contains(err.Error(), "rules[0].match") || !strings.Contains(err.Error(), "failed to create CEL environment") {
+		t.Fatalf("parseConfig() error = %v, want the CEL environment error of rules[0].match", err)
+	}
+}
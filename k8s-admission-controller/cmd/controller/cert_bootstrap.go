@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// caCertKey holds the CA bundle injected into the webhook configurations:
+	// the active CA first, followed by the CAs it replaced until they expire
+	caCertKey = "ca.crt"
+	// caKeyKey holds the key of the active CA
+	caKeyKey = "ca.key"
+	// caValidity is the lifetime of a generated CA
+	caValidity = 10 * 365 * 24 * time.Hour
+	// certSyncInterval is the period at which the leader checks the
+	// certificates and the CA bundles of the webhook configurations
+	certSyncInterval = time.Minute
+)
+
+// certBootstrapOptions configure the certificates generated by the webhook
+// for clusters without cert-manager
+type certBootstrapOptions struct {
+	enabled     bool
+	secretName  string
+	validity    time.Duration
+	renewBefore time.Duration
+}
+
+// certBootstrapper keeps a CA and a serving certificate for the Service of
+// the webhook in a Secret, and injects the CA bundle into the webhook
+// configurations. Only the leader among the replicas writes; every replica
+// serves the certificate of the Secret.
+type certBootstrapper struct {
+	client   kubernetes.Interface
+	webhook  webhookOptions
+	options  certBootstrapOptions
+	dnsNames []string
+	certs    *certStore
+	loaded   chan struct{}
+}
+
+// startCertBootstrap starts the leader election and the watch of the
+// certificate Secret, and blocks until the first certificate is loaded or
+// stopCh is closed
+func startCertBootstrap(config *rest.Config, webhook webhookOptions, options certBootstrapOptions, stopCh <-chan struct{}) (*certStore, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	b := &certBootstrapper{
+		client:   clientset,
+		webhook:  webhook,
+		options:  options,
+		dnsNames: webhook.dnsNames(),
+		certs:    &certStore{},
+		loaded:   make(chan struct{}),
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, informerResyncPeriod,
+		informers.WithNamespace(webhook.namespace),
+		informers.WithTweakListOptions(func(listOptions *metav1.ListOptions) {
+			listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", options.secretName).String()
+		}))
+	_, err = factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: b.loadSecret,
+		UpdateFunc: func(_, obj interface{}) {
+			b.loadSecret(obj)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch certificate Secret: %v", err)
+	}
+	factory.Start(stopCh)
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get leader election identity: %v", err)
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: webhook.namespace, Name: webhook.serviceName + "-certs"},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            "certificate bootstrap",
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.WithField("identity", identity).Info("Leading certificate bootstrap")
+				wait.UntilWithContext(ctx, func(ctx context.Context) {
+					if err := b.sync(ctx); err != nil {
+						log.WithError(err).Error("Failed to bootstrap certificates")
+					}
+				}, certSyncInterval)
+			},
+			OnStoppedLeading: func() {
+				log.WithField("identity", identity).Info("Stopped leading certificate bootstrap")
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create leader elector: %v", err)
+	}
+	go elector.Run(wait.ContextForChannel(stopCh))
+
+	log.WithFields(log.Fields{
+		"namespace": webhook.namespace,
+		"secret":    options.secretName,
+	}).Info("Waiting for bootstrapped certificate")
+	select {
+	case <-b.loaded:
+	case <-stopCh:
+		return nil, fmt.Errorf("stopped before a certificate was loaded")
+	}
+	return b.certs, nil
+}
+
+// loadSecret serves the certificate of the Secret
+func (b *certBootstrapper) loadSecret(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+
+	logger := log.WithField("secret", secret.Namespace+"/"+secret.Name)
+	previous := b.certs.current.Load()
+	if err := b.certs.load(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		logger.WithError(err).Error("Keeping last good certificate")
+		return
+	}
+	if current := b.certs.current.Load().Leaf; previous == nil || !current.Equal(previous.Leaf) {
+		logger.WithFields(log.Fields{
+			"serialNumber": current.SerialNumber.String(),
+			"notAfter":     current.NotAfter,
+		}).Info("Serving bootstrapped certificate")
+	}
+	if previous == nil {
+		close(b.loaded)
+	}
+}
+
+// sync generates the CA and the serving certificate when they are missing,
+// invalid or due for renewal, and injects the CA bundle into the webhook
+// configurations. A new CA is injected before a certificate it signed is
+// served, and the CA it replaces stays trusted until it expires, so that
+// replicas still serving the previous certificate keep working.
+func (b *certBootstrapper) sync(ctx context.Context) error {
+	secrets := b.client.CoreV1().Secrets(b.webhook.namespace)
+	secret, err := secrets.Get(ctx, b.options.secretName, metav1.GetOptions{})
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.options.secretName,
+				Namespace: b.webhook.namespace,
+				Labels:    map[string]string{"app": b.webhook.serviceName},
+			},
+			Type: corev1.SecretTypeTLS,
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get certificate Secret: %v", err)
+	}
+
+	data := maps.Clone(secret.Data)
+	if data == nil {
+		data = map[string][]byte{}
+	}
+	renewAt := time.Now().Add(b.options.renewBefore)
+	logger := log.WithField("secret", b.webhook.namespace+"/"+b.options.secretName)
+
+	ca, err := parseCertificateAuthority(data[caCertKey], data[caKeyKey])
+	if err != nil || ca.cert.NotAfter.Before(renewAt) {
+		if err != nil {
+			logger.WithError(err).Info("Generating CA")
+		} else {
+			logger.WithField("notAfter", ca.cert.NotAfter).Info("Renewing CA")
+		}
+		if ca, err = newCertificateAuthority(b.webhook.serviceName+"-ca", caValidity); err != nil {
+			return err
+		}
+		bundle := ca.certPEM
+		if previous, err := parseCertificates(data[caCertKey]); err == nil {
+			for _, cert := range previous {
+				if time.Now().Before(cert.NotAfter) {
+					bundle = append(bundle, encodeCertificate(cert)...)
+				}
+			}
+		}
+		data[caCertKey] = bundle
+		data[caKeyKey] = ca.keyPEM
+	}
+
+	if err := b.injectCABundle(ctx, data[caCertKey]); err != nil {
+		return err
+	}
+
+	// A new CA always renews the serving certificate, which it did not issue
+	if !b.needsRenewal(data, ca, renewAt) {
+		return nil
+	}
+	certPEM, keyPEM, err := ca.sign(b.dnsNames, b.options.validity)
+	if err != nil {
+		return err
+	}
+	data[corev1.TLSCertKey] = certPEM
+	data[corev1.TLSPrivateKeyKey] = keyPEM
+	secret.Data = data
+
+	if exists {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write certificate Secret: %v", err)
+	}
+	logger.WithField("dnsNames", b.dnsNames).Info("Issued serving certificate")
+	return nil
+}
+
+// needsRenewal reports whether the serving certificate in data is missing,
+// not issued by ca for the DNS names of the Service, or due for renewal
+func (b *certBootstrapper) needsRenewal(data map[string][]byte, ca *certificateAuthority, renewAt time.Time) bool {
+	cert, err := parseKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	return err != nil || !ca.issued(cert, b.dnsNames) || cert.NotAfter.Before(renewAt)
+}
+
+// injectCABundle sets bundle on the webhooks of the webhook configurations
+// calling the Service. Configurations that do not exist yet are skipped
+// until the next sync.
+func (b *certBootstrapper) injectCABundle(ctx context.Context, bundle []byte) error {
+	admission := b.client.AdmissionregistrationV1()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := admission.MutatingWebhookConfigurations().Get(ctx, b.webhook.mutatingConfig, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			changed = b.setCABundle(&config.Webhooks[i].ClientConfig, bundle) || changed
+		}
+		if !changed {
+			return nil
+		}
+		_, err = admission.MutatingWebhookConfigurations().Update(ctx, config, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to inject CA bundle into MutatingWebhookConfiguration %s: %v", b.webhook.mutatingConfig, err)
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := admission.ValidatingWebhookConfigurations().Get(ctx, b.webhook.validatingConfig, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		changed := false
+		for i := range config.Webhooks {
+			changed = b.setCABundle(&config.Webhooks[i].ClientConfig, bundle) || changed
+		}
+		if !changed {
+			return nil
+		}
+		_, err = admission.ValidatingWebhookConfigurations().Update(ctx, config, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to inject CA bundle into ValidatingWebhookConfiguration %s: %v", b.webhook.validatingConfig, err)
+	}
+	return nil
+}
+
+// setCABundle sets bundle on a client config calling the Service, reporting
+// whether it changed
+func (b *certBootstrapper) setCABundle(config *admissionregistrationv1.WebhookClientConfig, bundle []byte) bool {
+	service := config.Service
+	if service == nil || service.Namespace != b.webhook.namespace || service.Name != b.webhook.serviceName {
+		return false
+	}
+	if bytes.Equal(config.CABundle, bundle) {
+		return false
+	}
+	config.CABundle = bundle
+	return true
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestBootstrapper returns a bootstrapper for the default Service, whose
+// API server holds objects and a MutatingWebhookConfiguration calling it
+func newTestBootstrapper(t *testing.T, objects ...runtime.Object) *certBootstrapper {
+	t.Helper()
+	webhook := webhookOptions{
+		namespace:        "default",
+		serviceName:      "pod-admission-controller",
+		mutatingConfig:   "pod-creation-webhook",
+		validatingConfig: "pod-status-validator",
+	}
+	objects = append(objects, &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: webhook.mutatingConfig},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: "pod-creation-webhook.default.svc.cluster.local",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{Namespace: webhook.namespace, Name: webhook.serviceName},
+			},
+		}},
+	})
+	return &certBootstrapper{
+		client:   fake.NewClientset(objects...),
+		webhook:  webhook,
+		options:  certBootstrapOptions{secretName: "webhook-tls", validity: 90 * 24 * time.Hour, renewBefore: 30 * 24 * time.Hour},
+		dnsNames: webhook.dnsNames(),
+	}
+}
+
+func newTestCA(t *testing.T, validity time.Duration) *certificateAuthority {
+	t.Helper()
+	ca, err := newCertificateAuthority("pod-admission-controller-ca", validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// testSecret returns the certificate Secret holding bundle, the key of ca and
+// a serving certificate of ca valid for validity
+func testSecret(t *testing.T, ca *certificateAuthority, bundle []byte, validity time.Duration) *corev1.Secret {
+	t.Helper()
+	certPEM, keyPEM, err := ca.sign(webhookOptions{namespace: "default", serviceName: "pod-admission-controller"}.dnsNames(), validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "webhook-tls"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			caCertKey:               bundle,
+			caKeyKey:                ca.keyPEM,
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+}
+
+// syncSecret syncs the bootstrapper and returns its certificate Secret, along
+// with the CA bundle injected into the webhook configuration
+func syncSecret(t *testing.T, b *certBootstrapper) (*corev1.Secret, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	if err := b.sync(ctx); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	secret, err := b.client.CoreV1().Secrets("default").Get(ctx, "webhook-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := b.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "pod-creation-webhook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret, config.Webhooks[0].ClientConfig.CABundle
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	b := newTestBootstrapper(t)
+	ca := newTestCA(t, caValidity)
+	certPEM, keyPEM, err := ca.sign(b.dnsNames, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM, otherKeyPEM, err := ca.sign([]string{"other.default.svc"}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM}
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		ca      *certificateAuthority
+		renewAt time.Time
+		want    bool
+	}{
+		{name: "missing certificate", data: map[string][]byte{}, ca: ca, renewAt: time.Now(), want: true},
+		{name: "valid certificate", data: valid, ca: ca, renewAt: time.Now()},
+		{name: "certificate due for renewal", data: valid, ca: ca, renewAt: time.Now().Add(48 * time.Hour), want: true},
+		{name: "certificate of another CA", data: valid, ca: newTestCA(t, caValidity), renewAt: time.Now(), want: true},
+		{
+			name:    "certificate for other names",
+			data:    map[string][]byte{corev1.TLSCertKey: otherPEM, corev1.TLSPrivateKeyKey: otherKeyPEM},
+			ca:      ca,
+			renewAt: time.Now(),
+			want:    true,
+		},
+		{
+			name:    "mismatched key",
+			data:    map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: otherKeyPEM},
+			ca:      ca,
+			renewAt: time.Now(),
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.needsRenewal(tt.data, tt.ca, tt.renewAt); got != tt.want {
+				t.Errorf("needsRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertBootstrapSync(t *testing.T) {
+	b := newTestBootstrapper(t)
+	secret, injected := syncSecret(t, b)
+
+	ca, err := parseCertificateAuthority(secret.Data[caCertKey], secret.Data[caKeyKey])
+	if err != nil {
+		t.Fatalf("generated CA: %v", err)
+	}
+	cert, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatalf("generated key pair: %v", err)
+	}
+	if !ca.issued(cert, b.dnsNames) {
+		t.Error("serving certificate was not issued by the generated CA")
+	}
+	if !bytes.Equal(injected, secret.Data[caCertKey]) {
+		t.Error("injected CA bundle is not the bundle of the Secret")
+	}
+
+	// A valid certificate is kept
+	if again, _ := syncSecret(t, b); !bytes.Equal(again.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Error("sync() renewed a valid certificate")
+	}
+}
+
+func TestCertBootstrapRenewal(t *testing.T) {
+	ca := newTestCA(t, caValidity)
+	previous := testSecret(t, ca, ca.certPEM, 7*24*time.Hour)
+	b := newTestBootstrapper(t, previous)
+
+	secret, injected := syncSecret(t, b)
+	if !bytes.Equal(secret.Data[caCertKey], ca.certPEM) || !bytes.Equal(secret.Data[caKeyKey], ca.keyPEM) {
+		t.Error("sync() renewed a valid CA")
+	}
+	if !bytes.Equal(injected, ca.certPEM) {
+		t.Error("injected CA bundle is not the bundle of the Secret")
+	}
+	if bytes.Equal(secret.Data[corev1.TLSCertKey], previous.Data[corev1.TLSCertKey]) {
+		t.Fatal("sync() kept a certificate due for renewal")
+	}
+	cert, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.issued(cert, b.dnsNames) || cert.NotAfter.Before(time.Now().Add(b.options.validity-time.Hour)) {
+		t.Errorf("renewed certificate valid until %s was not issued by the CA for %s", cert.NotAfter, b.options.validity)
+	}
+}
+
+func TestCertBootstrapCARotation(t *testing.T) {
+	expiring := newTestCA(t, 7*24*time.Hour)
+	expired := newTestCA(t, -time.Minute)
+	bundle := append(append([]byte{}, expiring.certPEM...), expired.certPEM...)
+	b := newTestBootstrapper(t, testSecret(t, expiring, bundle, 24*time.Hour))
+
+	secret, injected := syncSecret(t, b)
+	if !bytes.Equal(injected, secret.Data[caCertKey]) {
+		t.Error("injected CA bundle is not the bundle of the Secret")
+	}
+
+	// The renewed CA comes first, and the CA it replaces stays trusted until
+	// it expires. Expired CAs are dropped.
+	certs, err := parseCertificates(secret.Data[caCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || certs[0].Equal(expiring.cert) || !certs[1].Equal(expiring.cert) {
+		t.Fatalf("CA bundle holds %d certificates, want the renewed CA followed by the expiring one", len(certs))
+	}
+
+	ca, err := parseCertificateAuthority(secret.Data[caCertKey], secret.Data[caKeyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.cert.Equal(certs[0]) {
+		t.Error("CA key does not belong to the first CA of the bundle")
+	}
+	cert, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.issued(cert, b.dnsNames) {
+		t.Error("serving certificate was not reissued by the renewed CA")
+	}
+}
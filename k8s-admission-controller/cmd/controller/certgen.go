@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// certificateAuthority signs the serving certificates of the webhook
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// certPEM and keyPEM are the PEM encodings of cert and key
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCertificateAuthority generates a self-signed CA valid for validity
+func newCertificateAuthority(commonName string, validity time.Duration) (*certificateAuthority, error) {
+	key, keyPEM, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+
+	return &certificateAuthority{
+		cert:    cert,
+		key:     key,
+		certPEM: encodeCertificate(cert),
+		keyPEM:  keyPEM,
+	}, nil
+}
+
+// parseCertificateAuthority loads a CA from the first certificate of certPEM
+// and its key
+func parseCertificateAuthority(certPEM, keyPEM []byte) (*certificateAuthority, error) {
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded CA key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %v", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", parsed)
+	}
+	if !certs[0].IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", certs[0].Subject.CommonName)
+	}
+
+	return &certificateAuthority{
+		cert:    certs[0],
+		key:     key,
+		certPEM: encodeCertificate(certs[0]),
+		keyPEM:  keyPEM,
+	}, nil
+}
+
+// sign issues a serving certificate for dnsNames, valid for validity but no
+// longer than the CA itself
+func (ca *certificateAuthority) sign(dnsNames []string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, keyPEM, err := generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create serving certificate: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// issued reports whether cert was signed by the CA for exactly dnsNames
+func (ca *certificateAuthority) issued(cert *x509.Certificate, dnsNames []string) bool {
+	return cert.CheckSignatureFrom(ca.cert) == nil && slices.Equal(cert.DNSNames, dnsNames)
+}
+
+// parseKeyPair returns the leaf certificate of a PEM encoded key pair, which
+// must match
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// parseCertificates decodes all certificates of a PEM bundle
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate")
+	}
+	return certs, nil
+}
+
+func encodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// generateKey creates an ECDSA P-256 key and its PKCS #8 PEM encoding
+func generateKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// certStore holds the serving certificate of the webhook and swaps it
+// atomically when the files in its directory change, so that certificates
+// rotated by cert-manager are served without a restart. Bootstrapped
+// certificates are loaded from their Secret instead.
+type certStore struct {
+	dir     string
+	current atomic.Pointer[tls.Certificate]
+}
+
+// newCertStore loads the tls.crt and tls.key pair from dir
+func newCertStore(dir string) (*certStore, error) {
+	store := &certStore{dir: dir}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reload reads the key pair from the directory of the store
+func (s *certStore) reload() error {
+	certPEM, err := os.ReadFile(filepath.Join(s.dir, corev1.TLSCertKey))
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(s.dir, corev1.TLSPrivateKeyKey))
+	if err != nil {
+		return fmt.Errorf("failed to read key: %v", err)
+	}
+	return s.load(certPEM, keyPEM)
+}
+
+// load activates the PEM encoded key pair when it is valid. The previous
+// certificate stays active on error.
+func (s *certStore) load(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %v", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse certificate: %v", err)
+		}
+	}
+
+	s.current.Store(&cert)
+	certificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+	return nil
+}
+
+// getCertificate serves the active certificate, as tls.Config.GetCertificate
+func (s *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.current.Load(), nil
+}
+
+// notAfter returns the expiry of the active certificate
+func (s *certStore) notAfter() time.Time {
+	return s.current.Load().Leaf.NotAfter
+}
+
+// watch reloads the certificate whenever its directory changes until ctx is
+// done. Secret volumes are updated by swapping a symlink, so the directory is
+// watched rather than the files.
+func (s *certStore) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create certificate watcher: %v", err)
+	}
+
+	if err := watcher.Add(s.dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch certificate directory: %v", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				s.handleChange()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Error("Certificate watcher error")
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *certStore) handleChange() {
+	logger := log.WithField("certDir", s.dir)
+
+	previous := s.current.Load().Leaf
+	if err := s.reload(); err != nil {
+		logger.WithError(err).WithField("notAfter", previous.NotAfter).Error("Keeping last good certificate")
+		return
+	}
+	if current := s.current.Load().Leaf; !current.Equal(previous) {
+		logger.WithFields(log.Fields{
+			"serialNumber": current.SerialNumber.String(),
+			"notAfter":     current.NotAfter,
+		}).Info("Serving renewed certificate")
+	}
+}
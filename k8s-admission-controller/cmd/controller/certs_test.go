@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// writeKeyPair publishes a new self-signed key pair in dir the way the
+// kubelet updates Secret volumes: the files link into a timestamped
+// directory, which is swapped by renaming the ..data symlink.
+func writeKeyPair(t *testing.T, dir, version string) []byte {
+	t.Helper()
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("pod-admission-controller.default.svc", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versionDir := filepath.Join(dir, "..version-"+version)
+	if err := os.Mkdir(versionDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM} {
+		if err := os.WriteFile(filepath.Join(versionDir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Base(versionDir), filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+// serving returns the certificate served by store
+func serving(t *testing.T, store *certStore) []byte {
+	t.Helper()
+	cert, err := store.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "1")
+	store, err := newCertStore(dir)
+	if err != nil {
+		t.Fatalf("newCertStore() error = %v", err)
+	}
+	first := serving(t, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := store.watch(ctx); err != nil {
+		t.Fatalf("watch() error = %v", err)
+	}
+
+	writeKeyPair(t, dir, "2")
+	deadline := time.Now().Add(5 * time.Second)
+	for string(serving(t, store)) == string(first) {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertStoreKeepsLastGoodCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "1")
+	store, err := newCertStore(dir)
+	if err != nil {
+		t.Fatalf("newCertStore() error = %v", err)
+	}
+	first := serving(t, store)
+
+	// A key that does not match the certificate
+	if err := os.Remove(filepath.Join(dir, "..version-1", corev1.TLSPrivateKeyKey)); err != nil {
+		t.Fatal(err)
+	}
+	_, keyPEM, err := certutil.GenerateSelfSignedCertKey("other", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "..version-1", corev1.TLSPrivateKeyKey), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store.handleChange()
+	if string(serving(t, store)) != string(first) {
+		t.Error("handleChange() replaced the certificate with an invalid key pair")
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/google/cel-go/cel"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule targets
+const (
+	targetLabel      = "label"
+	targetAnnotation = "annotation"
+)
+
+// Rule actions
+const (
+	actionSet    = "set"
+	actionRemove = "remove"
+)
+
+// Value sources resolved from the pod at admission time. Sources other than
+// creator only apply to Pods; rules using them are skipped for other
+// resources.
+const (
+	sourceOwningResource     = "owningResource"
+	sourceOwningResourceName = "owningResourceName"
+	sourcePodIP              = "podIP"
+	sourceNodeName           = "nodeName"
+	// sourceCreator is the user creating the object, only known on CREATE
+	sourceCreator = "creator"
+)
+
+// missingLabelsValuesLabel marks pods whose labels still wait for scheduling data
+const missingLabelsValuesLabel = "missingLabelsValues"
+
+var (
+	supportedTargets = sets.New(targetLabel, targetAnnotation)
+	supportedActions = sets.New(actionSet, actionRemove)
+	supportedSources = sets.New(sourceOwningResource, sourceOwningResourceName, sourcePodIP, sourceNodeName, sourceCreator)
+)
+
+// deferredSources are only known once the pod has been scheduled and started
+var deferredSources = sets.New(sourcePodIP, sourceNodeName)
+
+// Config is the declarative configuration of the metadata applied by the webhook
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// PodTemplates locates the pod template of custom workload resources
+	PodTemplates []PodTemplate `json:"podTemplates,omitempty"`
+	// Owners maps owner kinds to the value of the owningResource source
+	Owners OwnerConfig `json:"owners,omitempty"`
+	// RequiredLabels are the labels checked by the validating endpoint
+	RequiredLabels []LabelRequirement `json:"requiredLabels,omitempty"`
+	// Enforcement selects how violations of required labels are handled
+	Enforcement EnforcementConfig `json:"enforcement,omitempty"`
+	// ExemptSubjects are the users, groups and service accounts whose
+	// requests are neither mutated nor validated
+	ExemptSubjects SubjectSelector `json:"exemptSubjects,omitempty"`
+	// NodeLabels are the labels copied from the Node onto pods once they are
+	// scheduled. The topology and instance type labels are copied when nil.
+	NodeLabels []string `json:"nodeLabels,omitempty"`
+	// Registration shapes the webhook configurations registered with
+	// --register-webhooks
+	Registration RegistrationConfig `json:"registration,omitempty"`
+}
+
+// Rule describes a single label or annotation applied to admitted objects
+type Rule struct {
+	// Name is the label or annotation key
+	Name string `json:"name"`
+	// Resources scopes the rule to resource kinds. Only Pods are matched
+	// when empty.
+	Resources []ResourceSelector `json:"resources,omitempty"`
+	// Target is either label (default) or annotation
+	Target string `json:"target,omitempty"`
+	// Action is either set (default) or remove
+	Action string `json:"action,omitempty"`
+	// Match is a CEL condition that must hold for the rule to apply
+	Match string `json:"match,omitempty"`
+	// Value is a constant value, mutually exclusive with Source, Template
+	// and Expression
+	Value string `json:"value,omitempty"`
+	// Source resolves the value from the pod
+	Source string `json:"source,omitempty"`
+	// Template renders the value from the pod and admission request
+	Template string `json:"template,omitempty"`
+	// Expression computes the value with CEL
+	Expression string `json:"expression,omitempty"`
+	// Inherit copies keys from the object's Namespace instead of setting
+	// a single Name
+	Inherit *InheritSpec `json:"inherit,omitempty"`
+	// Default is used when Source, Template or Expression cannot be resolved
+	Default string `json:"default,omitempty"`
+	// Override replaces a value already set by the user or by an earlier rule
+	Override bool `json:"override,omitempty"`
+	// Subjects limits the rule to requests made by the selected users,
+	// groups or service accounts
+	Subjects *SubjectSelector `json:"subjects,omitempty"`
+	// OptIn limits the rule to objects whose Namespace or own annotations
+	// list its name in admission.jumads.com/enable-rules
+	OptIn bool `json:"optIn,omitempty"`
+
+	tmpl       *template.Template
+	match      cel.Program
+	expression cel.Program
+	owners     *OwnerConfig
+}
+
+// defaultConfig mirrors the labels the webhook has always applied
+func defaultConfig() *Config {
+	return &Config{
+		Rules: []Rule{
+			{Inherit: &InheritSpec{From: inheritLabels, Keys: []string{"environment"}}, Override: true},
+			{Name: "environment", Value: "production"},
+			{Name: "owningResource", Source: sourceOwningResource, Default: "None", Override: true},
+			{Name: "owningResourceName", Source: sourceOwningResourceName, Default: "None", Override: true},
+			{Name: "ipAddress", Source: sourcePodIP, Default: "pending", Override: true},
+			{Name: "nodeName", Source: sourceNodeName, Default: "pending", Override: true},
+		},
+		RequiredLabels: defaultRequiredLabels(),
+	}
+}
+
+// parseConfig decodes a YAML or JSON document, rejecting unknown fields
+func parseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %v", err)
+	}
+
+	if errs := config.validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %v", errs.ToAggregate())
+	}
+
+	if errs := config.compile(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %v", errs.ToAggregate())
+	}
+
+	return config, nil
+}
+
+func (c *Config) validate() field.ErrorList {
+	errs := validatePodTemplates(c.PodTemplates, field.NewPath("podTemplates"))
+	errs = append(errs, c.Owners.validate(field.NewPath("owners"))...)
+	errs = append(errs, validateNodeLabels(c.NodeLabels, field.NewPath("nodeLabels"))...)
+	errs = append(errs, validateLabelRequirements(c.RequiredLabels, field.NewPath("requiredLabels"))...)
+	errs = append(errs, c.Enforcement.validate(field.NewPath("enforcement"))...)
+	errs = append(errs, c.ExemptSubjects.validate(field.NewPath("exemptSubjects"))...)
+	errs = append(errs, c.Registration.validate(field.NewPath("registration"))...)
+	seen := map[string]sets.Set[string]{
+		targetLabel:      sets.New[string](),
+		targetAnnotation: sets.New[string](),
+	}
+
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		path := field.NewPath("rules").Index(i)
+
+		errs = append(errs, validateResourceSelectors(rule.Resources, path.Child("resources"))...)
+		if rule.Subjects != nil {
+			errs = append(errs, rule.Subjects.validate(path.Child("subjects"))...)
+		}
+
+		if rule.Target == "" {
+			rule.Target = targetLabel
+		}
+		if rule.Action == "" {
+			rule.Action = actionSet
+		}
+		if !supportedTargets.Has(rule.Target) {
+			errs = append(errs, field.NotSupported(path.Child("target"), rule.Target, sets.List(supportedTargets)))
+			continue
+		}
+		if !supportedActions.Has(rule.Action) {
+			errs = append(errs, field.NotSupported(path.Child("action"), rule.Action, sets.List(supportedActions)))
+		}
+
+		if rule.Inherit != nil {
+			errs = append(errs, rule.Inherit.validate(path.Child("inherit"))...)
+			if rule.Name != "" || rule.Action != actionSet || rule.OptIn {
+				errs = append(errs, field.Forbidden(path, "inherit rules cannot set a name, action or optIn"))
+			}
+		} else if rule.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "name is required"))
+		} else {
+			for _, msg := range validation.IsQualifiedName(rule.Name) {
+				errs = append(errs, field.Invalid(path.Child("name"), rule.Name, msg))
+			}
+			if rule.Target == targetLabel && rule.Name == missingLabelsValuesLabel {
+				errs = append(errs, field.Forbidden(path.Child("name"), "label is managed by the webhook"))
+			}
+			if seen[rule.Target].Has(rule.Name) {
+				errs = append(errs, field.Duplicate(path.Child("name"), rule.Name))
+			}
+			seen[rule.Target].Insert(rule.Name)
+		}
+
+		valueFields := 0
+		for _, set := range []bool{rule.Value != "", rule.Source != "", rule.Template != "", rule.Expression != "", rule.Inherit != nil} {
+			if set {
+				valueFields++
+			}
+		}
+		if rule.Action == actionRemove && (valueFields > 0 || rule.Default != "") {
+			errs = append(errs, field.Forbidden(path, "remove rules cannot set a value"))
+		}
+		if valueFields > 1 {
+			errs = append(errs, field.Forbidden(path, "value, source, template, expression and inherit are mutually exclusive"))
+		}
+		if rule.Source != "" && !supportedSources.Has(rule.Source) {
+			errs = append(errs, field.NotSupported(path.Child("source"), rule.Source, sets.List(supportedSources)))
+		}
+
+		if rule.Target == targetLabel {
+			for _, msg := range validation.IsValidLabelValue(rule.Value) {
+				errs = append(errs, field.Invalid(path.Child("value"), rule.Value, msg))
+			}
+			for _, msg := range validation.IsValidLabelValue(rule.Default) {
+				errs = append(errs, field.Invalid(path.Child("default"), rule.Default, msg))
+			}
+		}
+	}
+
+	return errs
+}
+
+// compile parses the templates and CEL expressions of the rules
+func (c *Config) compile() field.ErrorList {
+	errs := compileLabelRequirements(c.RequiredLabels, field.NewPath("requiredLabels"))
+
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		path := field.NewPath("rules").Index(i)
+		rule.owners = &c.Owners
+
+		if rule.Template != "" {
+			tmpl, err := parseTemplate(rule.Name, rule.Template)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("template"), rule.Template, err.Error()))
+			}
+			rule.tmpl = tmpl
+		}
+
+		if rule.Match != "" {
+			program, err := compileExpression(rule.Match, cel.BoolType)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("match"), rule.Match, err.Error()))
+			}
+			rule.match = program
+		}
+
+		if rule.Expression != "" {
+			program, err := compileExpression(rule.Expression, cel.StringType)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("expression"), rule.Expression, err.Error()))
+			}
+			rule.expression = program
+		}
+	}
+
+	return errs
+}
+
+// appliesTo reports whether the rule is scoped to the kind of gvk
+func (r *Rule) appliesTo(gvk schema.GroupVersionKind) bool {
+	return appliesTo(r.Resources, gvk)
+}
+
+// matches reports whether the rule applies to the object in ctx and its
+// match condition holds. A condition that fails to evaluate does not hold.
+// Subjects are not checked without an admission request, when the values
+// known after scheduling are computed.
+func (r *Rule) matches(ctx *ruleContext) bool {
+	if !r.appliesTo(ctx.gvk) || (r.Source != "" && r.Source != sourceCreator && !ctx.isPod()) {
+		return false
+	}
+	if r.Subjects != nil && ctx.request != nil && !r.Subjects.matches(ctx.request.UserInfo) {
+		return false
+	}
+	if r.match == nil {
+		return true
+	}
+
+	matched, err := evalMatch(r.match, ctx)
+	if err != nil {
+		log.WithError(err).WithFields(r.logFields()).Warn("Failed to evaluate match condition, skipping rule")
+		return false
+	}
+	return matched
+}
+
+// resolve computes the value of the rule for the object in ctx. The boolean
+// result is false when the value cannot be resolved and Default is used.
+func (r *Rule) resolve(ctx *ruleContext) (string, bool) {
+	obj := ctx.object.Object
+
+	var value string
+	var err error
+	switch {
+	case r.tmpl != nil:
+		value, err = executeTemplate(r.tmpl, ctx)
+	case r.expression != nil:
+		value, err = evalString(r.expression, ctx)
+	case r.Source == sourceOwningResource:
+		if owner := ctx.owner(); owner != nil {
+			value = r.owners.valueFor(owner)
+		}
+	case r.Source == sourceOwningResourceName:
+		if owner := ctx.owner(); owner != nil {
+			value = owner.Name
+		}
+	case r.Source == sourcePodIP:
+		value, _, _ = unstructured.NestedString(obj, "status", "podIP")
+	case r.Source == sourceNodeName:
+		value, _, _ = unstructured.NestedString(obj, "spec", "nodeName")
+	case r.Source == sourceCreator:
+		if ctx.request != nil && ctx.request.Operation == admissionv1.Create {
+			value = ctx.request.UserInfo.Username
+			if r.Target == targetLabel {
+				value = labelValue(value)
+			}
+		}
+	default:
+		return r.Value, true
+	}
+
+	if err == nil && r.Target == targetLabel {
+		if msgs := validation.IsValidLabelValue(value); len(msgs) > 0 {
+			err = fmt.Errorf("invalid label value %q: %s", value, strings.Join(msgs, "; "))
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithFields(r.logFields()).Debug("Failed to compute value, using default")
+		value = ""
+	}
+
+	if value == "" {
+		return r.Default, false
+	}
+	return value, true
+}
+
+func (r *Rule) logFields() log.Fields {
+	return log.Fields{"target": r.Target, "key": r.Name}
+}
+
+// metadataChanges are the changes applied to a label or annotation map
+type metadataChanges struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// isSet reports whether key is already set on the object or by an earlier rule
+func (c *metadataChanges) isSet(key string, current map[string]string) bool {
+	if _, ok := c.Set[key]; ok {
+		return true
+	}
+	_, ok := current[key]
+	return ok
+}
+
+// mutations are the metadata changes computed for an admitted object
+type mutations struct {
+	Labels      metadataChanges `json:"labels"`
+	Annotations metadataChanges `json:"annotations"`
+	// Exempted are the keys skipped because of opt-out annotations, or "*"
+	// when the object is exempted from all rules
+	Exempted []string `json:"exempted,omitempty"`
+
+	target     *metadataTarget
+	exemptions *exemptions
+	// changedBy names the rule setting or removing each key
+	changedBy map[metadataKey]string
+}
+
+// metadataKey identifies a label or annotation
+type metadataKey struct {
+	target string
+	key    string
+}
+
+func newMutations(target *metadataTarget) *mutations {
+	return &mutations{
+		Labels:      metadataChanges{Set: map[string]string{}},
+		Annotations: metadataChanges{Set: map[string]string{}},
+		target:      target,
+		changedBy:   map[metadataKey]string{},
+	}
+}
+
+// record attributes the change of key in target to the rule at index i of
+// the config. Inherit rules, which have no name, are named by their index.
+func (m *mutations) record(target, key string, i int, rule *Rule) {
+	name := rule.Name
+	if rule.Inherit != nil {
+		name = fmt.Sprintf("rules[%d]", i)
+	}
+	m.changedBy[metadataKey{target: target, key: key}] = name
+}
+
+// changedRules returns the names of the rules whose changes alter the object,
+// leaving out the rules setting keys to their current value
+func (m *mutations) changedRules() []string {
+	names := sets.New[string]()
+	for key, rule := range m.changedBy {
+		changes, current := m.changesFor(key.target)
+		if value, ok := changes.Set[key.key]; ok {
+			if currentValue, exists := current[key.key]; exists && currentValue == value {
+				continue
+			}
+		}
+		names.Insert(rule)
+	}
+	return sets.List(names)
+}
+
+// exempts reports whether key is opted out of, recording it in Exempted
+func (m *mutations) exempts(key string) bool {
+	if !m.exemptions.exempts(key) {
+		return false
+	}
+	if !slices.Contains(m.Exempted, key) {
+		m.Exempted = append(m.Exempted, key)
+	}
+	return true
+}
+
+// changesFor returns the changes of target and the current values
+func (m *mutations) changesFor(target string) (*metadataChanges, map[string]string) {
+	if target == targetAnnotation {
+		return &m.Annotations, m.target.annotations
+	}
+	return &m.Labels, m.target.labels
+}
+
+// mutationsFor computes the changes the config applies to the object in ctx.
+// Keys already set are skipped unless the rule overrides them, and keys
+// opted out of by annotations are skipped altogether. Immutable pod templates
+// are left unchanged on UPDATE. pending reports whether any deferred source
+// is still unresolved, or Node labels are to be copied onto an unscheduled pod.
+func (c *Config) mutationsFor(ctx *ruleContext) (result *mutations, pending bool) {
+	result = newMutations(c.metadataTargetFor(ctx.object, ctx.gvk.GroupKind()))
+	if !result.target.writable {
+		return result, false
+	}
+	if result.target.immutable && ctx.request != nil && ctx.request.Operation == admissionv1.Update {
+		return result, false
+	}
+	result.exemptions = c.exemptionsFor(ctx)
+	if result.exemptions.exemptsAll() {
+		result.Exempted = []string{wildcard}
+		return result, false
+	}
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		changes, current := result.changesFor(rule.Target)
+
+		if rule.OptIn && !result.exemptions.enables(rule.Name) {
+			continue
+		}
+
+		if rule.Inherit != nil {
+			if namespace := ctx.namespace(); namespace != nil && rule.matches(ctx) {
+				for key, value := range rule.Inherit.values(namespace, rule.Target) {
+					if result.exempts(key) || (changes.isSet(key, current) && !rule.Override) {
+						continue
+					}
+					changes.Set[key] = value
+					result.record(rule.Target, key, i, rule)
+				}
+			}
+			continue
+		}
+
+		if result.exempts(rule.Name) {
+			continue
+		}
+
+		_, exists := current[rule.Name]
+		if rule.Action == actionRemove {
+			if exists && rule.matches(ctx) {
+				changes.Remove = append(changes.Remove, rule.Name)
+				result.record(rule.Target, rule.Name, i, rule)
+			}
+			continue
+		}
+		if changes.isSet(rule.Name, current) && !rule.Override {
+			continue
+		}
+		if !rule.matches(ctx) {
+			continue
+		}
+
+		value, resolved := rule.resolve(ctx)
+		if !resolved && deferredSources.Has(rule.Source) {
+			pending = true
+		}
+		changes.Set[rule.Name] = value
+		result.record(rule.Target, rule.Name, i, rule)
+	}
+	// The labels of the Node are only known once the pod is scheduled
+	if ctx.isPod() && len(c.nodeLabelKeys()) > 0 {
+		if nodeName, _, _ := unstructured.NestedString(ctx.object.Object, "spec", "nodeName"); nodeName == "" {
+			pending = true
+		}
+	}
+	return result, pending
+}
+
+// deferredMutationsFor computes the values that depend on scheduling data,
+// including the labels copied from the pod's Node
+func (c *Config) deferredMutationsFor(lookupCtx context.Context, pod *corev1.Pod) (*mutations, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert pod: %v", err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+
+	ctx := newRuleContext(lookupCtx, obj, nil)
+	result := newMutations(c.metadataTargetFor(obj, podGroupKind))
+	result.exemptions = c.exemptionsFor(ctx)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Action != actionSet || !deferredSources.Has(rule.Source) || !rule.matches(ctx) {
+			continue
+		}
+		if (rule.OptIn && !result.exemptions.enables(rule.Name)) || result.exempts(rule.Name) {
+			continue
+		}
+		if value, resolved := rule.resolve(ctx); resolved {
+			changes, _ := result.changesFor(rule.Target)
+			changes.Set[rule.Name] = value
+		}
+	}
+	for key, value := range c.nodeLabelsFor(pod) {
+		if !result.exempts(key) {
+			result.Labels.Set[key] = value
+		}
+	}
+	return result, nil
+}
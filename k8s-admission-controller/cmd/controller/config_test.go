@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "valid rules",
+			config: `
+rules:
+  - name: environment
+    value: production
+  - name: team
+    target: annotation
+    template: '{{ index .object.metadata.labels "team" }}'
+  - name: legacy
+    action: remove
+`,
+		},
+		{
+			name:    "unknown field",
+			config:  "rules: []\nunknown: true\n",
+			wantErr: `unknown field "unknown"`,
+		},
+		{
+			name:    "missing name",
+			config:  "rules:\n  - value: production\n",
+			wantErr: "rules[0].name: Required value",
+		},
+		{
+			name:    "duplicate name",
+			config:  "rules:\n  - name: team\n    value: a\n  - name: team\n    value: b\n",
+			wantErr: "rules[1].name: Duplicate value",
+		},
+		{
+			name:    "same name on labels and annotations",
+			config:  "rules:\n  - name: team\n    value: a\n  - name: team\n    target: annotation\n    value: b\n",
+			wantErr: "",
+		},
+		{
+			name:    "unsupported target",
+			config:  "rules:\n  - name: team\n    target: taint\n",
+			wantErr: `rules[0].target: Unsupported value: "taint"`,
+		},
+		{
+			name:    "unsupported source",
+			config:  "rules:\n  - name: team\n    source: podName\n",
+			wantErr: `rules[0].source: Unsupported value: "podName"`,
+		},
+		{
+			name:    "mutually exclusive values",
+			config:  "rules:\n  - name: team\n    value: a\n    source: nodeName\n",
+			wantErr: "mutually exclusive",
+		},
+		{
+			name:    "remove with value",
+			config:  "rules:\n  - name: team\n    action: remove\n    value: a\n",
+			wantErr: "remove rules cannot set a value",
+		},
+		{
+			name:    "invalid label value",
+			config:  "rules:\n  - name: team\n    value: not a label value\n",
+			wantErr: "rules[0].value: Invalid value",
+		},
+		{
+			name:    "invalid annotation value is allowed",
+			config:  "rules:\n  - name: team\n    target: annotation\n    value: not a label value\n",
+			wantErr: "",
+		},
+		{
+			name:    "invalid key",
+			config:  "rules:\n  - name: -team\n    value: a\n",
+			wantErr: "rules[0].name: Invalid value",
+		},
+		{
+			name:    "reserved label",
+			config:  "rules:\n  - name: missingLabelsValues\n    value: \"true\"\n",
+			wantErr: "label is managed by the webhook",
+		},
+		{
+			name:    "inherit with name",
+			config:  "rules:\n  - name: team\n    inherit:\n      from: labels\n      keys: [team]\n",
+			wantErr: "inherit rules cannot set a name",
+		},
+		{
+			name:    "invalid template",
+			config:  "rules:\n  - name: team\n    template: '{{ .object.metadata.name'\n",
+			wantErr: "rules[0].template: Invalid value",
+		},
+		{
+			name:    "invalid required label mode",
+			config:  "rules: []\nrequiredLabels:\n  - name: team\n    mode: Block\n",
+			wantErr: `requiredLabels[0].mode: Unsupported value: "Block"`,
+		},
+		{
+			name:    "invalid required label pattern",
+			config:  "rules: []\nrequiredLabels:\n  - name: team\n    pattern: '['\n",
+			wantErr: "requiredLabels[0].pattern: Invalid value",
+		},
+		{
+			name:    "invalid registration",
+			config:  "rules: []\nregistration:\n  timeoutSeconds: 31\n",
+			wantErr: "registration.timeoutSeconds: Invalid value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseConfig() error = %v", err)
+				}
+				if config == nil {
+					t.Fatal("parseConfig() returned no config")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("parseConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	config, err := parseConfig([]byte("rules:\n  - name: team\n    value: a\n"))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	rule := config.Rules[0]
+	if rule.Target != targetLabel || rule.Action != actionSet {
+		t.Errorf("rule defaults = %s/%s, want %s/%s", rule.Target, rule.Action, targetLabel, actionSet)
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	store, err := newConfigStore("")
+	if err != nil {
+		t.Fatalf("newConfigStore() error = %v", err)
+	}
+	if got, want := len(store.Load().Config.Rules), len(defaultConfig().Rules); got != want {
+		t.Errorf("default rules = %d, want %d", got, want)
+	}
+}
+
+func TestMutationsFor(t *testing.T) {
+	config, err := parseConfig([]byte(`
+rules:
+  - name: environment
+    value: production
+    override: true
+  - name: team
+    value: web
+  - name: ipAddress
+    source: podIP
+    default: pending
+  - name: example.com/owner
+    target: annotation
+    value: platform
+  - name: legacy
+    action: remove
+`))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	pod := testPod()
+	pod.SetLabels(map[string]string{"environment": "staging", "team": "api", "legacy": "true"})
+
+	changes, _ := config.mutationsFor(newRuleContext(context.Background(), pod, testRequest("jane")))
+	if want := map[string]string{"environment": "production", "ipAddress": "pending"}; !maps.Equal(changes.Labels.Set, want) {
+		t.Errorf("mutationsFor() set labels %v, want %v", changes.Labels.Set, want)
+	}
+	if want := []string{"legacy"}; !slices.Equal(changes.Labels.Remove, want) {
+		t.Errorf("mutationsFor() removed labels %v, want %v", changes.Labels.Remove, want)
+	}
+	if want := map[string]string{"example.com/owner": "platform"}; !maps.Equal(changes.Annotations.Set, want) {
+		t.Errorf("mutationsFor() set annotations %v, want %v", changes.Annotations.Set, want)
+	}
+}
+
+// TestShippedConfig checks that the config shipped in the manifests still
+// mutates the pods created by the controllers of kube-system
+func TestShippedConfig(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "..", "manifests", "webhooks", "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(data, configMap); err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseConfig([]byte(configMap.Data["config.yaml"]))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	setTestNamespaces(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+	pod := testPod()
+	pod.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f", Controller: ptr.To(true)}})
+
+	changes, pending := config.mutationsFor(newRuleContext(context.Background(), pod, testRequest("system:serviceaccount:kube-system:replicaset-controller")))
+	if len(changes.Exempted) > 0 {
+		t.Errorf("mutationsFor() exempted %v", changes.Exempted)
+	}
+	for key, want := range map[string]string{"environment": "production", "owningResource": "ReplicaSet", "owningResourceName": "web-5d8f"} {
+		if got := changes.Labels.Set[key]; got != want {
+			t.Errorf("mutationsFor() set %s=%q, want %q", key, got, want)
+		}
+	}
+	if !pending {
+		t.Error("mutationsFor() pending = false, want true")
+	}
+}
+
+func TestMutationsForPending(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		nodeName    string
+		wantPending bool
+	}{
+		{name: "unresolved deferred source", config: "nodeLabels: []\nrules:\n  - name: ipAddress\n    source: podIP\n    default: pending\n", wantPending: true},
+		{name: "node labels without deferred rules", config: "nodeLabels: [topology.kubernetes.io/zone]\nrules:\n  - name: team\n    value: a\n", wantPending: true},
+		{name: "default node labels", config: "rules:\n  - name: team\n    value: a\n", wantPending: true},
+		{name: "scheduled pod", config: "rules:\n  - name: team\n    value: a\n", nodeName: "node-1"},
+		{name: "nothing deferred", config: "nodeLabels: []\nrules:\n  - name: team\n    value: a\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestNamespaces(t)
+			config, err := parseConfig([]byte(tt.config))
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+			pod := testPod()
+			if tt.nodeName != "" {
+				if err := unstructured.SetNestedField(pod.Object, tt.nodeName, "spec", "nodeName"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, pending := config.mutationsFor(newRuleContext(context.Background(), pod, testRequest("jane"))); pending != tt.wantPending {
+				t.Errorf("mutationsFor() pending = %v, want %v", pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestConfigStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("rules:\n  - name: team\n    value: a\n")
+	store, err := newConfigStore(path)
+	if err != nil {
+		t.Fatalf("newConfigStore() error = %v", err)
+	}
+	first := store.Load()
+
+	tests := []struct {
+		name        string
+		config      string
+		wantChanged bool
+		wantErr     bool
+		wantValue   string
+	}{
+		{name: "unchanged file", config: "rules:\n  - name: team\n    value: a\n", wantValue: "a"},
+		{name: "new revision", config: "rules:\n  - name: team\n    value: b\n", wantChanged: true, wantValue: "b"},
+		{name: "invalid revision keeps the last good one", config: "rules:\n  - name: team\n    source: podName\n", wantErr: true, wantValue: "b"},
+		{name: "undecodable revision keeps the last good one", config: "rules: [", wantErr: true, wantValue: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.config)
+			changed, err := store.reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("reload() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if got := store.Load().Config.Rules[0].Value; got != tt.wantValue {
+				t.Errorf("active value = %q, want %q", got, tt.wantValue)
+			}
+		})
+	}
+
+	if store.Load().Revision == first.Revision {
+		t.Error("revision did not change after reload")
+	}
+}
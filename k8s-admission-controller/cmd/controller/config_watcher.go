@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// ruleSet is an immutable revision of the rule configuration
+type ruleSet struct {
+	Config   *Config   `json:"config"`
+	Revision string    `json:"revision"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// configStore holds the active rule set and swaps it atomically on reload
+type configStore struct {
+	path    string
+	current atomic.Pointer[ruleSet]
+}
+
+// newConfigStore loads the initial configuration from path. An empty path
+// yields the default configuration, which is never reloaded.
+func newConfigStore(path string) (*configStore, error) {
+	store := &configStore{path: path}
+
+	if path == "" {
+		data, err := json.Marshal(defaultConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode default config: %v", err)
+		}
+		config, err := parseConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default config: %v", err)
+		}
+		store.current.Store(&ruleSet{Config: config, Revision: revisionOf(data), LoadedAt: time.Now()})
+		return store, nil
+	}
+
+	if _, err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load returns the active rule set
+func (s *configStore) Load() *ruleSet {
+	return s.current.Load()
+}
+
+// reload reads the configuration file and activates it when it parses and
+// validates. The previous revision stays active on error. It reports whether
+// a new revision was activated.
+func (s *configStore) reload() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config file %s: %v", s.path, err)
+	}
+
+	revision := revisionOf(data)
+	if active := s.current.Load(); active != nil && active.Revision == revision {
+		return false, nil
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return false, fmt.Errorf("rejected config revision %s: %v", revision, err)
+	}
+
+	s.current.Store(&ruleSet{Config: config, Revision: revision, LoadedAt: time.Now()})
+	return true, nil
+}
+
+// watch reloads the configuration whenever its directory changes until ctx
+// is done. The directory is watched rather than the file because ConfigMap
+// volumes are updated by swapping a symlink.
+func (s *configStore) watch(ctx context.Context) error {
+	if s.path == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %v", err)
+	}
+
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config directory: %v", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				s.handleChange()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Error("Config watcher error")
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *configStore) handleChange() {
+	logger := log.WithField("configFile", s.path)
+
+	previous := s.Load().Revision
+	changed, err := s.reload()
+	if err != nil {
+		logger.WithError(err).WithField("revision", previous).Error("Keeping last good config revision")
+		return
+	}
+	if changed {
+		logger.WithFields(log.Fields{
+			"previousRevision": previous,
+			"revision":         s.Load().Revision,
+			"rules":            len(s.Load().Config.Rules),
+		}).Info("Activated new config revision")
+	}
+}
+
+// handleDebugConfig reports the active rule set
+func (s *configStore) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	respBytes, err := json.Marshal(s.Load())
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to encode config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(respBytes); err != nil {
+		log.WithError(err).Error("Failed to write response")
+	}
+}
+
+// revisionOf returns a short content hash identifying a config revision
+func revisionOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
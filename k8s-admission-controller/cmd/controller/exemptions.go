@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Annotations set on Namespaces or on the admitted objects themselves to opt
+// out of rules or opt in to them, without changing the webhook selectors.
+// Required labels only honor the annotations of Namespaces, as the authors
+// of an object could otherwise exempt it themselves, and are set by mutation
+// despite the annotations of the object.
+const (
+	annotationPrefix = "admission.jumads.com/"
+	// disableAnnotation set to "true" exempts objects from all rules
+	disableAnnotation = annotationPrefix + "disable"
+	// disableKeysAnnotation lists the label and annotation keys, separated by
+	// commas, that are neither set nor required
+	disableKeysAnnotation = annotationPrefix + "disable-keys"
+	// enableRulesAnnotation lists the names of opt-in rules, separated by
+	// commas, applied to the objects
+	enableRulesAnnotation = annotationPrefix + "enable-rules"
+)
+
+// exemptions are the opt-outs and opt-ins requested by the annotations of an
+// object and its Namespace
+type exemptions struct {
+	// namespace are the opt-outs of the Namespace and of exempt subjects
+	namespace optOuts
+	// object are the opt-outs of the object itself, which do not cover the
+	// labels required for its kind
+	object   optOuts
+	required sets.Set[string]
+	optIns   sets.Set[string]
+	// sources are the annotations and exempt user the exemptions come from
+	sources log.Fields
+}
+
+// optOuts are the keys opted out of, or all of them
+type optOuts struct {
+	all  bool
+	keys sets.Set[string]
+}
+
+func (o *optOuts) has(key string) bool {
+	return o.all || o.keys.Has(key)
+}
+
+// exemptionsFor merges the opt-out and opt-in annotations of the Namespace
+// and of the object in ctx. Requests made by exempt subjects are exempted
+// from all rules. The annotations of the object do not opt out of the labels
+// required for its kind, which validation would deny otherwise.
+func (c *Config) exemptionsFor(ctx *ruleContext) *exemptions {
+	result := c.namespaceExemptionsFor(ctx)
+	result.add(strings.ToLower(ctx.gvk.Kind), ctx.object.GetAnnotations(), &result.object)
+	for i := range c.RequiredLabels {
+		if appliesTo(c.RequiredLabels[i].Resources, ctx.gvk) {
+			result.required.Insert(c.RequiredLabels[i].Name)
+		}
+	}
+	return result
+}
+
+// namespaceExemptionsFor returns the exemptions granted by the Namespace
+// annotations and the exempt subjects, leaving out the annotations of the
+// object in ctx
+func (c *Config) namespaceExemptionsFor(ctx *ruleContext) *exemptions {
+	result := &exemptions{
+		namespace: optOuts{keys: sets.New[string]()},
+		object:    optOuts{keys: sets.New[string]()},
+		required:  sets.New[string](),
+		optIns:    sets.New[string](),
+		sources:   log.Fields{},
+	}
+
+	if ctx.request != nil && c.ExemptSubjects.matches(ctx.request.UserInfo) {
+		result.namespace.all = true
+		result.sources["user"] = ctx.request.UserInfo.Username
+	}
+
+	if namespace := ctx.namespace(); namespace != nil {
+		result.add("namespace", namespace.Annotations, &result.namespace)
+	}
+	return result
+}
+
+// add records the opt-outs of annotations in optOuts, and their opt-ins
+func (e *exemptions) add(source string, annotations map[string]string, optOuts *optOuts) {
+	for _, annotation := range []string{disableAnnotation, disableKeysAnnotation, enableRulesAnnotation} {
+		value, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		e.sources[source+":"+annotation] = value
+
+		switch annotation {
+		case disableAnnotation:
+			optOuts.all = optOuts.all || value == "true"
+		case disableKeysAnnotation:
+			optOuts.keys.Insert(splitList(value)...)
+		case enableRulesAnnotation:
+			e.optIns.Insert(splitList(value)...)
+		}
+	}
+}
+
+// exempts reports whether key is opted out of
+func (e *exemptions) exempts(key string) bool {
+	return e.namespace.has(key) || (!e.required.Has(key) && e.object.has(key))
+}
+
+// exemptsAll reports whether all keys are opted out of
+func (e *exemptions) exemptsAll() bool {
+	return e.namespace.all || (e.object.all && e.required.Len() == 0)
+}
+
+// enables reports whether the opt-in rule named name is opted in to
+func (e *exemptions) enables(name string) bool {
+	return !e.namespace.all && !e.object.all && e.optIns.Has(name)
+}
+
+// splitList splits a comma separated annotation value, dropping blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const informerResyncPeriod = 10 * time.Minute
+
+// clientOptions configures the connection to the API server
+type clientOptions struct {
+	kubeconfig string
+	context    string
+	qps        float64
+	burst      int
+	userAgent  string
+}
+
+// restConfig builds the client configuration. The in-cluster configuration
+// is used unless a kubeconfig or context is given, or the webhook runs
+// outside a cluster, in which case the kubeconfig loading rules of kubectl
+// apply.
+func (o *clientOptions) restConfig() (*rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if o.kubeconfig == "" && o.context == "" {
+		config, err = rest.InClusterConfig()
+	}
+	if config == nil {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = o.kubeconfig
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client config: %v", err)
+	}
+
+	// The clients built from the config share a single rate limiter
+	config.QPS = float32(o.qps)
+	config.Burst = o.burst
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(config.QPS, config.Burst)
+	config.UserAgent = o.userAgent
+	return config, nil
+}
+
+// inClusterNamespace returns the namespace of the pod running the webhook,
+// from the POD_NAMESPACE variable or the service account token mount, and
+// falls back to "default" outside a cluster
+func inClusterNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return "default"
+}
+
+// startClusterCaches starts the shared informers backing the rules that read
+// cluster state and the pod labeler. Readiness waits for the rule caches to
+// sync, see waitForClusterCaches.
+// The returned channel is closed once the pod labeler has stopped after
+// stopCh is closed.
+func startClusterCaches(config *rest.Config, stopCh <-chan struct{}) (<-chan struct{}, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, informerResyncPeriod)
+	namespaceInformer := factory.Core().V1().Namespaces()
+	namespaceLister = namespaceInformer.Lister()
+	nodeInformer := factory.Core().V1().Nodes()
+	nodeLister = nodeInformer.Lister()
+	factory.Start(stopCh)
+
+	if owners, err = newOwnerResolver(config, stopCh); err != nil {
+		return nil, err
+	}
+
+	labeler, err := newPodLabeler(clientset, labelWorkers)
+	if err != nil {
+		return nil, err
+	}
+	labelerStopped := make(chan struct{})
+	go func() {
+		defer close(labelerStopped)
+		labeler.run(stopCh)
+	}()
+
+	go waitForClusterCaches(stopCh, namespaceInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced)
+
+	return labelerStopped, nil
+}
+
+// waitForClusterCaches marks the webhook ready once the caches synced or
+// cacheSyncTimeout elapsed, whichever comes first. Without the caches, e.g.
+// while the API server is unreachable, the webhook serves in a degraded mode
+// rather than not at all.
+func waitForClusterCaches(stopCh <-chan struct{}, synced ...cache.InformerSynced) {
+	ctx, cancel := context.WithTimeout(wait.ContextForChannel(stopCh), cacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		log.WithField("timeout", cacheSyncTimeout.String()).Warn("Cluster caches not synced, serving without Namespace and Node data")
+		cachesReady.Store(true)
+		if !cache.WaitForCacheSync(stopCh, synced...) {
+			return
+		}
+	}
+	cachesReady.Store(true)
+	clusterCachesSynced.Set(1)
+	log.Info("Cluster caches synced")
+}
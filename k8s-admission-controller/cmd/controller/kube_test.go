@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: jane
+- name: prod
+  context:
+    cluster: prod
+    user: jane
+current-context: dev
+users:
+- name: jane
+  user:
+    token: secret
+`
+
+// writeKubeconfig writes a kubeconfig with a dev and a prod context, and
+// runs the test outside a cluster
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	t.Setenv("KUBECONFIG", "")
+	return path
+}
+
+func TestRestConfig(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	tests := []struct {
+		name     string
+		options  clientOptions
+		env      string
+		wantHost string
+	}{
+		{name: "explicit kubeconfig", options: clientOptions{kubeconfig: path}, wantHost: "https://dev.example.com:6443"},
+		{name: "explicit context", options: clientOptions{kubeconfig: path, context: "prod"}, wantHost: "https://prod.example.com:6443"},
+		{name: "kubeconfig of the environment outside a cluster", env: path, wantHost: "https://dev.example.com:6443"},
+		{name: "context of the environment kubeconfig", options: clientOptions{context: "prod"}, env: path, wantHost: "https://prod.example.com:6443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.env)
+			tt.options.qps = 25
+			tt.options.burst = 50
+			tt.options.userAgent = "pod-admission-controller/test"
+
+			config, err := tt.options.restConfig()
+			if err != nil {
+				t.Fatalf("restConfig() error = %v", err)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("restConfig() host = %q, want %q", config.Host, tt.wantHost)
+			}
+			if config.BearerToken != "secret" {
+				t.Errorf("restConfig() token = %q, want the token of the kubeconfig user", config.BearerToken)
+			}
+			if config.QPS != 25 || config.Burst != 50 || config.UserAgent != "pod-admission-controller/test" {
+				t.Errorf("restConfig() QPS = %v, burst = %d, user agent = %q", config.QPS, config.Burst, config.UserAgent)
+			}
+			if config.RateLimiter == nil || config.RateLimiter.QPS() != 25 {
+				t.Error("restConfig() clients do not share a rate limiter with the configured QPS")
+			}
+		})
+	}
+}
+
+func TestRestConfigErrors(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	tests := []struct {
+		name    string
+		options clientOptions
+	}{
+		{name: "missing kubeconfig", options: clientOptions{kubeconfig: filepath.Join(t.TempDir(), "missing")}},
+		{name: "unknown context", options: clientOptions{kubeconfig: path, context: "staging"}},
+		{name: "no kubeconfig outside a cluster"},
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.options.restConfig(); err == nil {
+				t.Error("restConfig() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestWaitForClusterCaches(t *testing.T) {
+	previous := cacheSyncTimeout
+	cacheSyncTimeout = 50 * time.Millisecond
+	cachesReady.Store(false)
+	t.Cleanup(func() {
+		cacheSyncTimeout = previous
+		cachesReady.Store(false)
+	})
+
+	var synced atomic.Bool
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waitForClusterCaches(stopCh, synced.Load)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cachesReady.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("not ready once the cache sync timeout elapsed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	synced.Store(true)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitForClusterCaches() did not return once the caches synced")
+	}
+}
@@ -2,23 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
@@ -29,8 +34,59 @@ var (
 	codecs  = serializer.NewCodecFactory(scheme)
 	port    = ":8443"
 	certDir = "/certs/"
+
+	configFile   string
+	labelWorkers int
+	metricsAddr  string
+	otlpEndpoint string
+	audit        auditOptions
+	webhook      webhookOptions
+	bootstrap    certBootstrapOptions
+	kubeClient   clientOptions
+	rules        *configStore
+	owners       *ownerResolver
+	decisions    *decisionLog
+	certs        *certStore
+
+	// registerWebhooks creates or updates the webhook configurations at
+	// startup
+	registerWebhooks bool
+
+	shutdownGracePeriod time.Duration
+	shutdownTimeout     time.Duration
+	// shuttingDown fails readiness once the webhook received SIGTERM
+	shuttingDown atomic.Bool
+
+	// cacheSyncTimeout bounds the wait for the cluster caches before the
+	// webhook reports ready without them
+	cacheSyncTimeout time.Duration
+	// cachesReady passes readiness once the cluster caches synced or
+	// cacheSyncTimeout elapsed
+	cachesReady atomic.Bool
 )
 
+// webhookOptions identify the Service of the webhook and the webhook
+// configurations calling it
+type webhookOptions struct {
+	namespace        string
+	serviceName      string
+	mutatingConfig   string
+	validatingConfig string
+}
+
+// dnsNames are the names the API server may use to reach the Service
+func (o webhookOptions) dnsNames() []string {
+	service := o.serviceName + "." + o.namespace
+	return []string{o.serviceName, service, service + ".svc", service + ".svc.cluster.local"}
+}
+
+// auditOptions configure the sink of the decision audit log
+type auditOptions struct {
+	sink       string
+	maxSizeMB  int64
+	maxBackups int
+}
+
 func init() {
 	// Configure logging
 	log.SetFormatter(&log.JSONFormatter{
@@ -54,21 +110,146 @@ func init() {
 }
 
 func main() {
+	flag.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"), "Path to the YAML or JSON label rule configuration. Built-in defaults are used when empty.")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":9090", "Address serving Prometheus metrics over HTTP on /metrics. Disabled when empty.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"), "OTLP/HTTP endpoint receiving traces, e.g. http://otel-collector:4318. Tracing is disabled when empty.")
+	flag.StringVar(&audit.sink, "audit-sink", os.Getenv("AUDIT_SINK"), "Sink of the decision audit log: stdout, an http(s):// URL receiving newline delimited JSON, or the path of a local JSONL file. Disabled when empty.")
+	flag.Int64Var(&audit.maxSizeMB, "audit-file-max-size", 100, "Size in megabytes at which the audit file is rotated.")
+	flag.IntVar(&audit.maxBackups, "audit-file-max-backups", 5, "Number of rotated audit files to keep.")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 10*time.Second, "Time to keep serving with failing readiness after SIGTERM, so that the Service endpoints drop the pod before the server stops.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 20*time.Second, "Deadline to finish in-flight requests, label the queued pods and flush the audit log and spans once the grace period is over.")
+	flag.StringVar(&webhook.namespace, "namespace", inClusterNamespace(), "Namespace of the webhook Service.")
+	flag.StringVar(&webhook.serviceName, "service-name", "pod-admission-controller", "Name of the webhook Service.")
+	flag.StringVar(&webhook.mutatingConfig, "mutating-webhook-configuration", "pod-creation-webhook", "Name of the MutatingWebhookConfiguration calling the webhook.")
+	flag.StringVar(&webhook.validatingConfig, "validating-webhook-configuration", "pod-status-validator", "Name of the ValidatingWebhookConfiguration calling the webhook.")
+	flag.BoolVar(&bootstrap.enabled, "cert-bootstrap", false, "Generate a CA and serving certificate into a Secret and inject the CA bundle into the webhook configurations, instead of reading the certificate from "+certDir+".")
+	flag.StringVar(&bootstrap.secretName, "cert-secret", "webhook-tls", "Name of the Secret holding the bootstrapped certificates.")
+	flag.DurationVar(&bootstrap.validity, "cert-validity", 365*24*time.Hour, "Lifetime of bootstrapped serving certificates.")
+	flag.DurationVar(&bootstrap.renewBefore, "cert-renew-before", 30*24*time.Hour, "Time before expiry at which bootstrapped certificates are renewed.")
+	flag.BoolVar(&registerWebhooks, "register-webhooks", false, "Create or update the webhook configurations at startup from the endpoints of the webhook and the registration section of --config. Delete them with the uninstall command.")
+	flag.DurationVar(&cacheSyncTimeout, "cache-sync-timeout", 30*time.Second, "Time to wait for the Namespace and Node caches before reporting ready without them. Rules reading Namespaces and Nodes fall back to their defaults until the caches sync.")
+	flag.IntVar(&labelWorkers, "label-workers", 2, "Number of workers labelling pods once they are scheduled.")
+	flag.StringVar(&kubeClient.kubeconfig, "kubeconfig", "", "Path to a kubeconfig file. The in-cluster configuration is used when empty, falling back to $KUBECONFIG and ~/.kube/config outside a cluster.")
+	flag.StringVar(&kubeClient.context, "context", "", "Name of the kubeconfig context to use.")
+	flag.Float64Var(&kubeClient.qps, "kube-api-qps", 20, "Maximum queries per second to the API server.")
+	flag.IntVar(&kubeClient.burst, "kube-api-burst", 30, "Maximum burst of queries to the API server.")
+	flag.StringVar(&kubeClient.userAgent, "user-agent", "pod-admission-controller", "User agent sent to the API server.")
+	// "uninstall" deletes the webhook configurations and exits, taking the
+	// same flags as the webhook
+	uninstall := len(os.Args) > 1 && os.Args[1] == "uninstall"
+	args := os.Args[1:]
+	if uninstall {
+		args = args[1:]
+	}
+	_ = flag.CommandLine.Parse(args)
+
+	if uninstall {
+		if err := unregister(); err != nil {
+			log.WithError(err).Fatal("Failed to uninstall webhook configurations")
+		}
+		return
+	}
+
 	log.WithFields(log.Fields{
-		"port":     port,
-		"certDir":  certDir,
-		"certPath": certDir + "tls.crt",
-		"keyPath":  certDir + "tls.key",
-		"logLevel": log.GetLevel().String(),
+		"port":       port,
+		"certDir":    certDir,
+		"certPath":   certDir + "tls.crt",
+		"keyPath":    certDir + "tls.key",
+		"configFile": configFile,
+		"logLevel":   log.GetLevel().String(),
 	}).Info(fmt.Sprintf("Starting Admission Controller: build time %s", buildTime))
 
+	shutdownTracing, err := setupTracing(context.Background(), otlpEndpoint)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
+
+	rules, err = newConfigStore(configFile)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load configuration")
+	}
+	log.WithFields(log.Fields{
+		"revision": rules.Load().Revision,
+		"rules":    len(rules.Load().Config.Rules),
+	}).Info("Loaded rule configuration")
+
+	if err := rules.watch(context.Background()); err != nil {
+		log.WithError(err).Fatal("Failed to watch configuration")
+	}
+
+	// The API server is used to look up owners, Namespaces and Nodes, to
+	// label scheduled pods and to bootstrap certificates
+	stopCh := make(chan struct{})
+	restConfig, clientErr := kubeClient.restConfig()
+	if clientErr == nil {
+		traceClient(restConfig)
+	}
+
+	if registerWebhooks && clientErr != nil {
+		log.WithError(clientErr).Fatal("Failed to create client config required by the webhook registration")
+	}
+
+	if bootstrap.enabled {
+		if bootstrap.renewBefore >= bootstrap.validity {
+			log.Fatal("--cert-renew-before must be shorter than --cert-validity")
+		}
+		if clientErr != nil {
+			log.WithError(clientErr).Fatal("Failed to create client config required by the certificate bootstrap")
+		}
+		if certs, err = startCertBootstrap(restConfig, webhook, bootstrap, stopCh); err != nil {
+			log.WithError(err).Fatal("Failed to bootstrap TLS certificate")
+		}
+	} else {
+		if certs, err = newCertStore(certDir); err != nil {
+			log.WithError(err).Fatal("Failed to load TLS certificate")
+		}
+		if err := certs.watch(context.Background()); err != nil {
+			log.WithError(err).Fatal("Failed to watch TLS certificate")
+		}
+	}
+	log.WithField("notAfter", certs.notAfter()).Info("Loaded TLS certificate")
+
+	if audit.sink != "" {
+		sink, err := newAuditSink(audit.sink, audit.maxSizeMB<<20, audit.maxBackups)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to create audit sink")
+		}
+		decisions = newDecisionLog(sink)
+		log.WithField("sink", audit.sink).Info("Writing admission decisions to the audit sink")
+	}
+
+	// Without a client configuration owners are taken from the owner
+	// references and the other lookups are disabled
+	var labelerStopped <-chan struct{}
+	if clientErr != nil {
+		log.WithError(clientErr).Warn("Failed to create client config, cluster lookups are disabled")
+		cachesReady.Store(true)
+	} else if labelerStopped, err = startClusterCaches(restConfig, stopCh); err != nil {
+		log.WithError(err).Fatal("Failed to start cluster caches")
+	}
+
+	if metricsAddr != "" {
+		go func() {
+			if err := serveMetrics(metricsAddr); err != nil {
+				log.WithError(err).Fatal("Failed to serve metrics")
+			}
+		}()
+	}
+
 	// Create HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate-pod-creation", handlePodCreation)
-	mux.HandleFunc("/validate-pod-status", handlePodStatusChangeValidation)
+	for endpoint, handler := range map[string]http.HandlerFunc{
+		mutatePath:            handleMutation,
+		mutatePodCreationPath: handleMutation,
+		validatePath:          handleValidation,
+		validatePodStatusPath: handlePodStatusChangeValidation,
+	} {
+		mux.HandleFunc(endpoint, traceHandler(endpoint, instrument(endpoint, handler)))
+	}
 	mux.HandleFunc("/healthz", handleHealth)
 	mux.HandleFunc("/readyz", handleHealth)
 	mux.HandleFunc("/livez", handleHealth)
+	mux.HandleFunc("/debug/config", rules.handleDebugConfig)
 
 	server := &http.Server{
 		Addr:              port,
@@ -77,13 +258,82 @@ func main() {
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       10 * time.Second,
+		TLSConfig: &tls.Config{
+			GetCertificate: certs.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
 	}
 
-	if err := server.ListenAndServeTLS(certDir+"tls.crt", certDir+"tls.key"); err != nil {
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// The webhooks are registered once the port accepts reviews
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
 		log.WithError(err).Fatal("Failed to start server")
 	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		// The certificate is served by certs, which reloads it on rotation
+		serverErrors <- server.ServeTLS(listener, "", "")
+	}()
+
+	if registerWebhooks {
+		if err := register(restConfig); err != nil {
+			log.WithError(err).Fatal("Failed to register webhook configurations")
+		}
+	}
+
+	select {
+	case err := <-serverErrors:
+		log.WithError(err).Fatal("Failed to start server")
+	case <-signals.Done():
+	}
+	// A second signal terminates the webhook without draining
+	stop()
+
+	shutdown(server, stopCh, labelerStopped, shutdownTracing)
 }
 
+// shutdown drains the webhook after SIGTERM. Readiness fails first, so that
+// the API server stops sending reviews to the pod while it still answers
+// them. Once the grace period is over the in-flight requests are finished,
+// the queued pods labelled, and the audit log and spans flushed, all within
+// shutdownTimeout.
+func shutdown(server *http.Server, stopCh chan struct{}, labelerStopped <-chan struct{}, shutdownTracing func(context.Context) error) {
+	shuttingDown.Store(true)
+	server.SetKeepAlivesEnabled(false)
+	log.WithField("gracePeriod", shutdownGracePeriod.String()).Info("Shutting down, waiting for endpoints to drop the pod")
+	time.Sleep(shutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Failed to finish in-flight requests")
+	}
+
+	close(stopCh)
+	if labelerStopped != nil {
+		select {
+		case <-labelerStopped:
+		case <-ctx.Done():
+			log.Error("Timed out labelling the queued pods")
+		}
+	}
+
+	if err := decisions.close(ctx); err != nil {
+		log.WithError(err).Error("Failed to close the audit log")
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.WithError(err).Error("Failed to flush spans")
+	}
+	log.Info("Shutdown complete")
+}
+
+// handlePodStatusChangeValidation allows every status update of a pod. It is
+// no longer registered, and only answers the webhook configurations still
+// calling it.
 func handlePodStatusChangeValidation(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	logger := log.WithFields(log.Fields{
@@ -94,8 +344,9 @@ func handlePodStatusChangeValidation(w http.ResponseWriter, r *http.Request) {
 	})
 
 	defer func() {
-		if r := recover(); r != nil {
-			logger.WithField("panic", r).Error("Recovered from panic in mutation handler")
+		if p := recover(); p != nil {
+			panicsRecovered.WithLabelValues(r.URL.Path).Inc()
+			logger.WithField("panic", p).Error("Recovered from panic in mutation handler")
 			writeError(w, "Internal server error", http.StatusInternalServerError)
 		}
 		logger.WithField("duration", time.Since(startTime).String()).Info("Successfully validated status update request.")
@@ -117,18 +368,31 @@ func handlePodStatusChangeValidation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	review, pod, err := parseAdmissionReview(body)
+	ctx := r.Context()
+	_, span := tracer.Start(ctx, "decode")
+	review, obj, err := parseAdmissionReview(body)
+	endSpan(span, err)
 	if err != nil {
+		decodeFailures.WithLabelValues(r.URL.Path).Inc()
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	trace.SpanFromContext(ctx).SetAttributes(reviewAttributes(review.Request, obj.GetName())...)
 
-	// Start a goroutine to update pod labels without blocking the request
-	go func() {
-		if err := updatePodLabels(pod); err != nil {
-			log.WithError(err).Error("Failed to update pod labels")
-		}
-	}()
+	logger = logger.WithFields(log.Fields{
+		"uid":       review.Request.UID,
+		"namespace": review.Request.Namespace,
+		"name":      obj.GetName(),
+		"dryRun":    isDryRun(review.Request),
+	})
+
+	// Pods are labelled by the pod labeler once their persisted status reports
+	// an IP address and a node, so no work is scheduled here and dry-run
+	// requests get the same response without side effects.
+	if _, err := toPod(review, obj); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Create admission response
 	response := admissionv1.AdmissionResponse{
@@ -137,110 +401,135 @@ func handlePodStatusChangeValidation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send response
+	recordReview(r, review.Request, &response)
+	decisions.record(newDecision(r, startTime, review.Request, obj, &response, rules.Load().Revision))
 	reviewResponse := admissionv1.AdmissionReview{
 		TypeMeta: review.TypeMeta,
 		Response: &response,
 	}
 
+	_, span = tracer.Start(ctx, "write response")
 	respBytes, err := json.Marshal(reviewResponse)
 	if err != nil {
+		endSpan(span, err)
 		writeError(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(respBytes); err != nil {
+	_, err = w.Write(respBytes)
+	endSpan(span, err)
+	if err != nil {
 		logger.WithError(err).Error("Failed to write response")
 	}
 }
 
-// updatePodLabels patches a pod with the desired labels
-func updatePodLabels(pod *corev1.Pod) error {
-	// Create in-cluster config
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		writeError(nil, fmt.Sprintf("Failed to create in-cluster config: %v", err), http.StatusInternalServerError)
+// handleValidation denies objects that lack the required labels or set them
+// to values that are not allowed
+func handleValidation(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	logger := log.WithFields(log.Fields{
+		"method":    r.Method,
+		"path":      r.URL.Path,
+		"remoteIP":  r.RemoteAddr,
+		"userAgent": r.UserAgent(),
+	})
+
+	defer func() {
+		if p := recover(); p != nil {
+			panicsRecovered.WithLabelValues(r.URL.Path).Inc()
+			logger.WithField("panic", p).Error("Recovered from panic in validation handler")
+			writeError(w, "Internal server error", http.StatusInternalServerError)
+		}
+		logger.WithField("duration", time.Since(startTime).String()).Info("Successfully processed validating request.")
+	}()
+
+	if r.Method != http.MethodPost {
+		writeError(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Create Kubernetes client
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		writeError(nil, fmt.Sprintf("Failed to create Kubernetes client: %v", err), http.StatusInternalServerError)
-		panic(err.Error())
+	if r.Header.Get("Content-Type") != "application/json" {
+		writeError(w, "Invalid content type, expecting application/json", http.StatusUnsupportedMediaType)
+		return
 	}
 
-	// Watch for pod status changes until the pod has an IP address and node name
-	// then patch the pod with the desired labels
-	for {
-		pod, err = clientset.CoreV1().Pods("default").Get(context.TODO(), pod.Name, metav1.GetOptions{})
-		if err != nil {
-			writeError(nil, fmt.Sprintf("Failed to get pod: %v", err), http.StatusInternalServerError)
-		}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		if pod.Status.PodIP != "" && pod.Spec.NodeName != "" {
-			labels := map[string]string{
-				"missingLabelsValues": "false",
-				"ipAddress":           pod.Status.PodIP,
-				"nodeName":            pod.Spec.NodeName,
-			}
+	ctx := r.Context()
+	_, span := tracer.Start(ctx, "decode")
+	review, obj, err := parseAdmissionReview(body)
+	endSpan(span, err)
+	if err != nil {
+		decodeFailures.WithLabelValues(r.URL.Path).Inc()
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(reviewAttributes(review.Request, obj.GetName())...)
 
-			patchData, err := json.Marshal(map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"labels": labels,
-				},
-			})
-			if err != nil {
-				writeError(nil, fmt.Sprintf("Failed to marshal patch data: %v", err), http.StatusInternalServerError)
-			}
+	logger = logger.WithFields(log.Fields{
+		"uid":       review.Request.UID,
+		"kind":      review.Request.Kind.Kind,
+		"operation": review.Request.Operation,
+		"namespace": review.Request.Namespace,
+		"name":      obj.GetName(),
+		"dryRun":    isDryRun(review.Request),
+	})
 
-			_, err = clientset.CoreV1().Pods(pod.Namespace).Patch(
-				context.TODO(),
-				pod.Name,
-				types.MergePatchType,
-				patchData,
-				metav1.PatchOptions{},
-			)
-			if err != nil {
-				writeError(nil, fmt.Sprintf("Failed to patch pod: %v", err), http.StatusInternalServerError)
-			}
+	// Check the object against the required labels of the active rule set
+	ruleSet := rules.Load()
+	evalCtx, span := tracer.Start(ctx, "evaluate rules")
+	ruleCtx := newRuleContext(evalCtx, obj, review.Request)
+	violations, exempted := ruleSet.Config.violationsFor(ruleCtx)
+	span.End()
 
-			break
-		}
+	response := admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	enforce(&response, ruleCtx, violations)
+	if len(exempted) > 0 {
+		logger.WithField("exempted", exempted).Info("Skipped required labels exempted by annotations")
+		addAuditAnnotation(&response, "exempted", strings.Join(exempted, ","))
+	}
+	for mode, errs := range violations {
+		logger.WithFields(log.Fields{
+			"configRevision": ruleSet.Revision,
+			"mode":           mode,
+			"violations":     errs.ToAggregate().Error(),
+		}).Info("Object has missing or invalid labels")
 	}
 
-	// Define labels to add
-	labels := map[string]string{
-		"environment": "production",
-		"ipAddress":   pod.Status.PodIP,
-		"nodeName":    pod.Spec.NodeName,
+	// Send response
+	recordReview(r, review.Request, &response)
+	record := newDecision(r, startTime, review.Request, obj, &response, ruleSet.Revision)
+	record.addViolations(violations, exempted)
+	decisions.record(record)
+	reviewResponse := admissionv1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: &response,
 	}
 
-	// Create JSON patch for labels
-	patchData, err := json.Marshal(map[string]interface{}{
-		"metadata": map[string]interface{}{
-			"labels": labels,
-		},
-	})
+	_, span = tracer.Start(ctx, "write response")
+	respBytes, err := json.Marshal(reviewResponse)
 	if err != nil {
-		writeError(nil, fmt.Sprintf("Failed to marshal patch data: %v", err), http.StatusInternalServerError)
+		endSpan(span, err)
+		writeError(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Patch the pod using the correct type from `types` package
-	_, err = clientset.CoreV1().Pods(pod.Namespace).Patch(
-		context.TODO(),
-		pod.Name,
-		types.MergePatchType,
-		patchData,
-		metav1.PatchOptions{},
-	)
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(respBytes)
+	endSpan(span, err)
 	if err != nil {
-		writeError(nil, fmt.Sprintf("Failed to patch pod: %v", err), http.StatusInternalServerError)
+		logger.WithError(err).Error("Failed to write response")
 	}
-
-	log.Printf("Successfully patched pod %s/%s with labels: %v", pod.Namespace, pod.Name, labels)
-
-	return nil
 }
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	urlPath := r.URL.Path
 	logger := log.WithFields(log.Fields{
@@ -260,28 +549,33 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 
 	// For readiness check, verify we can process requests
 	if probeType == "readiness" || probeType == "health" {
+		if shuttingDown.Load() {
+			http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if !cachesReady.Load() {
+			http.Error(w, "Cluster caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+
 		// Check if server is accepting connections
-		_, err := net.DialTimeout("tcp", port, 1*time.Second)
+		conn, err := net.DialTimeout("tcp", port, 1*time.Second)
 		if err != nil {
 			logger.WithError(err).Error("Readiness probe failed: cannot accept connections")
 			http.Error(w, "Not ready", http.StatusServiceUnavailable)
 			return
 		}
+		// Left open, the connection would hold up the shutdown of the server
+		conn.Close()
 	}
 
-	// For liveness check, verify critical components
+	// For liveness check, verify critical components. A restart only helps
+	// once the served certificate has expired: a renewed certificate that
+	// failed to load is then retried from scratch.
 	if probeType == "liveness" || probeType == "health" {
-		// Verify TLS files exist
-		if _, err := os.Stat(certDir + "tls.crt"); err != nil {
-			msg := "TLS certificate not found"
-			logger.WithError(err).Error(msg)
-			http.Error(w, msg, http.StatusInternalServerError)
-			return
-		}
-
-		if _, err := os.Stat(certDir + "tls.key"); err != nil {
-			msg := "TLS key not found"
-			logger.WithError(err).Error(msg)
+		if notAfter := certs.notAfter(); time.Now().After(notAfter) {
+			msg := "TLS certificate expired"
+			logger.WithField("notAfter", notAfter).Error(msg)
 			http.Error(w, msg, http.StatusInternalServerError)
 			return
 		}
@@ -292,7 +586,14 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Health check completed successfully")
 }
 
-func parseAdmissionReview(body []byte) (*admissionv1.AdmissionReview, *corev1.Pod, error) {
+// isDryRun reports whether the request will not be persisted. It is only
+// recorded in logs, spans and audit records: the handlers never write or
+// schedule work, so dry-run requests get the same response.
+func isDryRun(request *admissionv1.AdmissionRequest) bool {
+	return request.DryRun != nil && *request.DryRun
+}
+
+func parseAdmissionReview(body []byte) (*admissionv1.AdmissionReview, *unstructured.Unstructured, error) {
 	if len(body) == 0 {
 		return nil, nil, fmt.Errorf("empty request body")
 	}
@@ -307,16 +608,29 @@ func parseAdmissionReview(body []byte) (*admissionv1.AdmissionReview, *corev1.Po
 		return nil, nil, fmt.Errorf("admission review request is nil")
 	}
 
-	if review.Request.Kind.Kind != "Pod" {
-		return nil, nil, fmt.Errorf("only supports Pod mutations, got %s", review.Request.Kind.Kind)
+	if len(review.Request.Object.Raw) == 0 {
+		return nil, nil, fmt.Errorf("admission review request has no object")
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(review.Request.Object.Raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s object: %v", review.Request.Kind.Kind, err)
 	}
 
-	pod := corev1.Pod{}
-	if err := json.Unmarshal(review.Request.Object.Raw, &pod); err != nil {
-		return nil, nil, fmt.Errorf("failed to decode pod object: %v", err)
+	return &review, obj, nil
+}
+
+// toPod converts the admitted object into a Pod
+func toPod(review *admissionv1.AdmissionReview, obj *unstructured.Unstructured) (*corev1.Pod, error) {
+	if schema.GroupVersionKind(review.Request.Kind).GroupKind() != podGroupKind {
+		return nil, fmt.Errorf("only supports Pods, got %s", review.Request.Kind.Kind)
 	}
 
-	return &review, &pod, nil
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pod); err != nil {
+		return nil, fmt.Errorf("failed to decode pod object: %v", err)
+	}
+	return pod, nil
 }
 
 func writeError(w http.ResponseWriter, message string, code int) {
@@ -328,53 +642,33 @@ func writeError(w http.ResponseWriter, message string, code int) {
 	http.Error(w, message, code)
 }
 
-// createPatch generates a JSON patch for updating pod labels
-func createPatch(pod *corev1.Pod, labels map[string]string, logger *log.Entry) string {
-	var operations []string
+// createPatch returns the minimal JSON patch applying changes to obj, or nil
+// when the object is left unchanged
+func createPatch(obj *unstructured.Unstructured, changes *mutations, pending bool, logger *log.Entry) ([]byte, error) {
+	target := changes.target
 
-	if pod.Labels == nil {
-		operations = append(operations, `{"op":"add","path":"/metadata/labels","value":{}}`)
-		pod.Labels = make(map[string]string)
+	// Add or remove missingLabelsValues label based on pending status of the deferred labels
+	if pending {
+		changes.Labels.Set[missingLabelsValuesLabel] = "true"
+	} else if target.labels[missingLabelsValuesLabel] == "true" {
+		changes.Labels.Remove = append(changes.Labels.Remove, missingLabelsValuesLabel)
 	}
 
-	// Helper function to add or replace label
-	addOrReplaceLabel := func(name, value string) {
-
-		if pod.Labels != nil {
-			// Add or remove missingLabelsValues label based on pending status of both ipAddress and nodeName
-			if value == "pending" {
-				operations = append(operations, `{"op":"add","path":"/metadata/labels/missingLabelsValues","value":"true"}`)
-			} else if pod.Labels["missingLabelsValues"] == "true" && (labels["ipAddress"] != "pending" && labels["nodeName"] != "pending") {
-				// remove missingLabelsValues label if both ipAddress and nodeName are not pending
-				operations = append(operations, `{"op":"remove","path":"/metadata/labels/missingLabelsValues"}`)
-			}
-
-			if _, exists := pod.Labels[name]; exists {
-				// Label exists, replace it
-				operations = append(operations, fmt.Sprintf(`{"op":"replace","path":"/metadata/labels/%s","value":"%s"}`, name, value))
-			} else {
-				// Label doesn't exist, add it
-				operations = append(operations, fmt.Sprintf(`{"op":"add","path":"/metadata/labels/%s","value":"%s"}`, name, value))
-			}
-		} else {
-			// No labels map, just add the label
-			operations = append(operations, fmt.Sprintf(`{"op":"add","path":"/metadata/labels/%s","value":"%s"}`, name, value))
-		}
+	mutated, err := changes.apply(obj)
+	if err != nil {
+		return nil, err
 	}
 
-	// Iterate over labels and add/replace each one
-	for name, value := range labels {
-		addOrReplaceLabel(name, value)
+	patch, err := marshalPatch(diffPatch("", obj.Object, mutated.Object))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode patch: %v", err)
 	}
 
-	// Create the final patch
-	patch := fmt.Sprintf("[%s]", strings.Join(operations, ","))
-
-	logger.WithField("patch", patch).Debug("Generated JSON patch")
-	return patch
+	logger.WithField("patch", string(patch)).Debug("Generated JSON patch")
+	return patch, nil
 }
 
-func handlePodCreation(w http.ResponseWriter, r *http.Request) {
+func handleMutation(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	logger := log.WithFields(log.Fields{
 		"method":    r.Method,
@@ -384,8 +678,9 @@ func handlePodCreation(w http.ResponseWriter, r *http.Request) {
 	})
 
 	defer func() {
-		if r := recover(); r != nil {
-			logger.WithField("panic", r).Error("Recovered from panic in mutation handler")
+		if p := recover(); p != nil {
+			panicsRecovered.WithLabelValues(r.URL.Path).Inc()
+			logger.WithField("panic", p).Error("Recovered from panic in mutation handler")
 			writeError(w, "Internal server error", http.StatusInternalServerError)
 		}
 		logger.WithField("duration", time.Since(startTime).String()).Info("Successfully processed mutating request.")
@@ -407,88 +702,120 @@ func handlePodCreation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	review, pod, err := parseAdmissionReview(body)
+	ctx := r.Context()
+	_, span := tracer.Start(ctx, "decode")
+	review, obj, err := parseAdmissionReview(body)
+	endSpan(span, err)
 	if err != nil {
+		decodeFailures.WithLabelValues(r.URL.Path).Inc()
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	trace.SpanFromContext(ctx).SetAttributes(reviewAttributes(review.Request, obj.GetName())...)
 
 	logger = logger.WithFields(log.Fields{
 		"uid":       review.Request.UID,
-		"namespace": pod.Namespace,
-		"name":      pod.Name,
+		"kind":      review.Request.Kind.Kind,
+		"operation": review.Request.Operation,
+		"namespace": review.Request.Namespace,
+		"name":      obj.GetName(),
+		"dryRun":    isDryRun(review.Request),
 	})
-	logger.Info("Processing pod creation request.")
-
-	// Determine owning resource type
-	owningResource := "None"
-	if len(pod.OwnerReferences) > 0 {
-		owner := pod.OwnerReferences[0].Kind
-		if owner == "ReplicaSet" {
-			owningResource = "ReplicaSet"
-		} else if owner == "StatefulSet" {
-			owningResource = "StatefulSet"
-		} else if owner == "Job" {
-			owningResource = "Job"
-		}
-	}
+	logger.Info("Processing mutating request.")
 
-	// Get IP address and node name
-	ipAddress := pod.Status.PodIP
-	nodeName := pod.Spec.NodeName
-
-	if ipAddress == "" {
-		ipAddress = "pending"
-	}
-
-	if nodeName == "" {
-		nodeName = "pending"
-	}
+	// Compute the labels and annotations to be changed from the active rule set
+	ruleSet := rules.Load()
+	evalCtx, span := tracer.Start(ctx, "evaluate rules")
+	changes, pending := ruleSet.Config.mutationsFor(newRuleContext(evalCtx, obj, review.Request))
+	span.End()
 
 	logger = logger.WithFields(log.Fields{
-		"environment":    "production",
-		"owningResource": owningResource,
-		"ipAddress":      ipAddress,
-		"nodeName":       nodeName,
+		"configRevision": ruleSet.Revision,
+		"labels":         changes.Labels,
+		"annotations":    changes.Annotations,
+		"pending":        pending,
+		"exempted":       changes.Exempted,
 	})
 
-	// Define the labels to be added/replaced
-	labels := map[string]string{
-		"environment":    "production",
-		"owningResource": owningResource,
-		"ipAddress":      ipAddress,
-		"nodeName":       nodeName,
-	}
-
 	// Generate the patch
-	patch := createPatch(pod, labels, logger)
+	_, span = tracer.Start(ctx, "generate patch")
+	patch, err := createPatch(obj, changes, pending, logger)
+	endSpan(span, err)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to create patch: %v", err), http.StatusInternalServerError)
+		return
+	}
+	recordPatchOperations(changes)
 
 	// Create admission response
 	response := admissionv1.AdmissionResponse{
 		UID:     review.Request.UID,
 		Allowed: true,
-		Patch:   []byte(patch),
-		PatchType: func() *admissionv1.PatchType {
-			t := admissionv1.PatchTypeJSONPatch
-			return &t
-		}(),
+	}
+	if patch != nil {
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = patch
+		response.PatchType = &patchType
+	}
+	if len(changes.Exempted) > 0 {
+		logger.WithFields(changes.exemptions.sources).Info("Skipped keys exempted by annotations")
+		addAuditAnnotation(&response, "exempted", strings.Join(changes.Exempted, ","))
 	}
 
 	// Send response
+	recordReview(r, review.Request, &response)
+	record := newDecision(r, startTime, review.Request, obj, &response, ruleSet.Revision)
+	record.addMutations(changes)
+	decisions.record(record)
 	reviewResponse := admissionv1.AdmissionReview{
 		TypeMeta: review.TypeMeta,
 		Response: &response,
 	}
 
+	_, span = tracer.Start(ctx, "write response")
 	respBytes, err := json.Marshal(reviewResponse)
 	if err != nil {
+		endSpan(span, err)
 		writeError(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(respBytes); err != nil {
+	_, err = w.Write(respBytes)
+	endSpan(span, err)
+	if err != nil {
 		writeError(w, fmt.Sprintf("Failed to write response: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
+
+// register creates or updates the webhook configurations from the rule
+// configuration loaded at startup
+func register(restConfig *rest.Config) error {
+	registrar, err := newWebhookRegistrar(restConfig, webhook)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registrationTimeout)
+	defer cancel()
+	caBundle, err := registrationCABundle(ctx, registrar.client, webhook, bootstrap)
+	if err != nil {
+		return err
+	}
+	return registrar.register(ctx, rules.Load().Config, caBundle)
+}
+
+// unregister deletes the webhook configurations
+func unregister() error {
+	restConfig, err := kubeClient.restConfig()
+	if err != nil {
+		return fmt.Errorf("failed to create client config: %v", err)
+	}
+	registrar, err := newWebhookRegistrar(restConfig, webhook)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), registrationTimeout)
+	defer cancel()
+	return registrar.unregister(ctx)
+}
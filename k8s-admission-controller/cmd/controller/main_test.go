@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+// setTestRules serves config as the active rule set for the duration of the
+// test
+func setTestRules(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newConfigStore(path)
+	if err != nil {
+		t.Fatalf("newConfigStore() error = %v", err)
+	}
+	previous := rules
+	rules = store
+	t.Cleanup(func() { rules = previous })
+}
+
+// review posts an AdmissionReview of request to handler and returns the
+// response body
+func review(t *testing.T, handler http.HandlerFunc, path string, request *admissionv1.AdmissionRequest) []byte {
+	t.Helper()
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", path, recorder.Code, recorder.Body)
+	}
+	return recorder.Body.Bytes()
+}
+
+func TestDryRunResponses(t *testing.T) {
+	setTestNamespaces(t)
+	setTestRules(t, `
+rules:
+  - name: environment
+    value: production
+  - name: ipAddress
+    source: podIP
+    default: pending
+requiredLabels:
+  - name: team
+`)
+	pod, err := json.Marshal(testPod().Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, handler := range map[string]http.HandlerFunc{
+		mutatePath:            handleMutation,
+		mutatePodCreationPath: handleMutation,
+		validatePath:          handleValidation,
+		validatePodStatusPath: handlePodStatusChangeValidation,
+	} {
+		t.Run(path, func(t *testing.T) {
+			request := testRequest("jane")
+			request.UID = "7b3e2f0c"
+			request.Object = runtime.RawExtension{Raw: pod}
+
+			want := review(t, handler, path, request)
+			request.DryRun = ptr.To(true)
+			if got := review(t, handler, path, request); !bytes.Equal(got, want) {
+				t.Errorf("dry-run response = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestShutdownFailsReadinessFirst(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", handleHealth)
+	server := &http.Server{Handler: mux}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.Serve(listener)
+	}()
+
+	previousPort, previousGrace, previousTimeout := port, shutdownGracePeriod, shutdownTimeout
+	port, shutdownGracePeriod, shutdownTimeout = listener.Addr().String(), 500*time.Millisecond, time.Second
+	cachesReady.Store(true)
+	t.Cleanup(func() {
+		port, shutdownGracePeriod, shutdownTimeout = previousPort, previousGrace, previousTimeout
+		shuttingDown.Store(false)
+		cachesReady.Store(false)
+	})
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	readyz := func() (int, error) {
+		resp, err := client.Get("http://" + port + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	if code, err := readyz(); err != nil || code != http.StatusOK {
+		t.Fatalf("/readyz before shutdown = %d, %v, want 200", code, err)
+	}
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		shutdown(server, stopCh, nil, func(context.Context) error { return nil })
+	}()
+
+	// Readiness fails while the server still answers, so that the endpoints
+	// drop the pod before it stops accepting reviews
+	deadline := time.Now().Add(shutdownGracePeriod / 2)
+	for {
+		code, err := readyz()
+		if err != nil {
+			t.Fatalf("/readyz during the grace period: %v", err)
+		}
+		if code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("/readyz during the grace period = %d, want 503", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-served:
+		t.Fatal("server stopped before the grace period was over")
+	case <-stopCh:
+		t.Fatal("caches stopped before the grace period was over")
+	default:
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	<-served
+	<-stopCh
+	if _, err := readyz(); err == nil {
+		t.Error("server still serving after shutdown")
+	}
+}
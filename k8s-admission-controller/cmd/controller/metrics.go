@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const metricsNamespace = "admission_webhook"
+
+// Results of admission requests
+const (
+	resultAllowed = "allowed"
+	resultPatched = "patched"
+	resultDenied  = "denied"
+	resultError   = "error"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Admission requests by endpoint, operation and result.",
+	}, []string{"endpoint", "operation", "result"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of admission requests by endpoint.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"endpoint"})
+
+	patchOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_operations_total",
+		Help:      "Labels and annotations set or removed by admission patches, by target, key and action.",
+	}, []string{"target", "key", "action"})
+
+	decodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decode_failures_total",
+		Help:      "Admission reviews that could not be decoded, by endpoint.",
+	}, []string{"endpoint"})
+
+	panicsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "panics_recovered_total",
+		Help:      "Panics recovered in admission handlers, by endpoint.",
+	}, []string{"endpoint"})
+
+	podLabelQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_label_queue_depth",
+		Help:      "Pods waiting in the queue of the pod labeler.",
+	})
+
+	podLabelResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_label_results_total",
+		Help:      "Attempts of the pod labeler to label a pod, by result.",
+	}, []string{"result"})
+
+	certificateExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Expiry of the serving certificate, as a Unix timestamp.",
+	})
+
+	auditRecordsWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_records_written_total",
+		Help:      "Admission decisions written to the audit sink.",
+	})
+
+	auditRecordsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_records_dropped_total",
+		Help:      "Admission decisions lost before reaching the audit sink, by reason.",
+	}, []string{"reason"})
+
+	timeToLabel = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_time_to_label_seconds",
+		Help:      "Time from the creation of a pod until the pod labeler set its scheduling labels.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	clusterCachesSynced = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cluster_caches_synced",
+		Help:      "Whether the Namespace and Node caches are synced (1) or the webhook serves without them (0).",
+	})
+)
+
+// requestMetrics collects the labels of an admission request metric while
+// the request is handled
+type requestMetrics struct {
+	operation string
+	result    string
+}
+
+type requestMetricsKey struct{}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument records the count and latency of the requests served by
+// handler. Requests the handler answers with an HTTP error are counted with
+// the error result.
+func instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		metrics := &requestMetrics{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), requestMetricsKey{}, metrics)))
+
+		if recorder.status >= http.StatusBadRequest || metrics.result == "" {
+			metrics.result = resultError
+		}
+		requestsTotal.WithLabelValues(endpoint, metrics.operation, metrics.result).Inc()
+		requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordReview records the operation and result of an answered admission
+// request
+func recordReview(r *http.Request, request *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse) {
+	metrics, ok := r.Context().Value(requestMetricsKey{}).(*requestMetrics)
+	if !ok {
+		return
+	}
+
+	metrics.operation = string(request.Operation)
+	switch {
+	case !response.Allowed:
+		metrics.result = resultDenied
+	case response.Patch != nil:
+		metrics.result = resultPatched
+	default:
+		metrics.result = resultAllowed
+	}
+}
+
+// recordPatchOperations counts the keys changes sets to a new value or
+// removes
+func recordPatchOperations(changes *mutations) {
+	for _, target := range []string{targetLabel, targetAnnotation} {
+		metadata, current := changes.changesFor(target)
+		for key, value := range metadata.Set {
+			if existing, ok := current[key]; !ok || existing != value {
+				patchOperations.WithLabelValues(target, key, actionSet).Inc()
+			}
+		}
+		for _, key := range metadata.Remove {
+			if _, ok := current[key]; ok {
+				patchOperations.WithLabelValues(target, key, actionRemove).Inc()
+			}
+		}
+	}
+}
+
+// serveMetrics serves the Prometheus metrics over plain HTTP on addr
+func serveMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
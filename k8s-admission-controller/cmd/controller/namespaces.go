@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Namespace metadata inherited by inherit rules
+const (
+	inheritLabels      = "labels"
+	inheritAnnotations = "annotations"
+)
+
+var supportedInheritSources = sets.New(inheritLabels, inheritAnnotations)
+
+// InheritSpec projects labels or annotations of the object's Namespace
+type InheritSpec struct {
+	// From is the Namespace metadata to read, labels or annotations
+	From string `json:"from"`
+	// Keys is the allow-list of Namespace keys to copy
+	Keys []string `json:"keys"`
+	// KeyPrefix rewrites the prefix of the copied keys
+	KeyPrefix *KeyPrefixRewrite `json:"keyPrefix,omitempty"`
+}
+
+// KeyPrefixRewrite removes Trim from the start of a key, then prepends Add
+type KeyPrefixRewrite struct {
+	Trim string `json:"trim,omitempty"`
+	Add  string `json:"add,omitempty"`
+}
+
+func (s *InheritSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if !supportedInheritSources.Has(s.From) {
+		errs = append(errs, field.NotSupported(path.Child("from"), s.From, sets.List(supportedInheritSources)))
+	}
+	if len(s.Keys) == 0 {
+		errs = append(errs, field.Required(path.Child("keys"), "at least one key is required"))
+	}
+	for i, key := range s.Keys {
+		for _, msg := range validation.IsQualifiedName(s.rewrite(key)) {
+			errs = append(errs, field.Invalid(path.Child("keys").Index(i), s.rewrite(key), msg))
+		}
+	}
+
+	return errs
+}
+
+// rewrite returns the key a Namespace key is copied to
+func (s *InheritSpec) rewrite(key string) string {
+	if s.KeyPrefix == nil {
+		return key
+	}
+	return s.KeyPrefix.Add + strings.TrimPrefix(key, s.KeyPrefix.Trim)
+}
+
+// values returns the allow-listed Namespace values keyed by their rewritten
+// keys. Label targets skip values that are not valid label values.
+func (s *InheritSpec) values(namespace *corev1.Namespace, target string) map[string]string {
+	source := namespace.Labels
+	if s.From == inheritAnnotations {
+		source = namespace.Annotations
+	}
+
+	values := make(map[string]string, len(s.Keys))
+	for _, key := range s.Keys {
+		value, ok := source[key]
+		if !ok {
+			continue
+		}
+		if target == targetLabel && len(validation.IsValidLabelValue(value)) > 0 {
+			log.WithFields(log.Fields{
+				"namespace": namespace.Name,
+				"key":       key,
+			}).Debug("Skipping Namespace value that is not a valid label value")
+			continue
+		}
+		values[s.rewrite(key)] = value
+	}
+	return values
+}
+
+// namespaceLister reads Namespaces from the shared informer cache. It is nil
+// when the webhook runs outside a cluster.
+var namespaceLister corelisters.NamespaceLister
+
+// lookupNamespace returns the Namespace from the informer cache, or nil when
+// it is not cached
+func lookupNamespace(name string) *corev1.Namespace {
+	if namespaceLister == nil || name == "" {
+		return nil
+	}
+
+	namespace, err := namespaceLister.Get(name)
+	if err != nil {
+		log.WithError(err).WithField("namespace", name).Debug("Namespace not found in cache")
+		return nil
+	}
+	return namespace
+}
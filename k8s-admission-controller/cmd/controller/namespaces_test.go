@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setTestNamespaces serves namespaces from the Namespace cache for the
+// duration of the test
+func setTestNamespaces(t *testing.T, namespaces ...*corev1.Namespace) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range namespaces {
+		if err := indexer.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := namespaceLister
+	namespaceLister = corelisters.NewNamespaceLister(indexer)
+	t.Cleanup(func() { namespaceLister = previous })
+}
+
+func TestInheritSpecValues(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "team-a",
+		Labels: map[string]string{
+			"team":                        "web",
+			"environment":                 "production",
+			"example.com/cost-center":     "cc-42",
+			"kubernetes.io/metadata.name": "team-a",
+		},
+		Annotations: map[string]string{
+			"example.com/owner":       "Web Team <web@example.com>",
+			"example.com/cost-center": "cc-42",
+		},
+	}}
+
+	tests := []struct {
+		name   string
+		spec   InheritSpec
+		target string
+		want   map[string]string
+	}{
+		{
+			name:   "allow-listed labels",
+			spec:   InheritSpec{From: inheritLabels, Keys: []string{"team", "missing"}},
+			target: targetLabel,
+			want:   map[string]string{"team": "web"},
+		},
+		{
+			name:   "trimmed prefix",
+			spec:   InheritSpec{From: inheritLabels, Keys: []string{"example.com/cost-center"}, KeyPrefix: &KeyPrefixRewrite{Trim: "example.com/"}},
+			target: targetLabel,
+			want:   map[string]string{"cost-center": "cc-42"},
+		},
+		{
+			name:   "added prefix",
+			spec:   InheritSpec{From: inheritLabels, Keys: []string{"team", "environment"}, KeyPrefix: &KeyPrefixRewrite{Add: "namespace.example.com/"}},
+			target: targetLabel,
+			want:   map[string]string{"namespace.example.com/team": "web", "namespace.example.com/environment": "production"},
+		},
+		{
+			name:   "replaced prefix",
+			spec:   InheritSpec{From: inheritAnnotations, Keys: []string{"example.com/cost-center"}, KeyPrefix: &KeyPrefixRewrite{Trim: "example.com/", Add: "billing.example.com/"}},
+			target: targetLabel,
+			want:   map[string]string{"billing.example.com/cost-center": "cc-42"},
+		},
+		{
+			name:   "annotation that is not a valid label value",
+			spec:   InheritSpec{From: inheritAnnotations, Keys: []string{"example.com/owner"}},
+			target: targetLabel,
+			want:   map[string]string{},
+		},
+		{
+			name:   "annotation copied onto annotations",
+			spec:   InheritSpec{From: inheritAnnotations, Keys: []string{"example.com/owner"}},
+			target: targetAnnotation,
+			want:   map[string]string{"example.com/owner": "Web Team <web@example.com>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.values(namespace, tt.target); !maps.Equal(got, tt.want) {
+				t.Errorf("values() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutationsForInherit(t *testing.T) {
+	config, err := parseConfig([]byte(`
+rules:
+  - inherit:
+      from: labels
+      keys: [team, environment]
+  - name: environment
+    value: production
+`))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		namespaces []*corev1.Namespace
+		want       map[string]string
+	}{
+		{
+			name:       "namespace labels",
+			namespaces: []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "web", "environment": "staging"}}}},
+			want:       map[string]string{"team": "web", "environment": "staging"},
+		},
+		{
+			name: "namespace not cached",
+			want: map[string]string{"environment": "production"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestNamespaces(t, tt.namespaces...)
+			changes, _ := config.mutationsFor(newRuleContext(context.Background(), testPod(), testRequest("jane")))
+			if !maps.Equal(changes.Labels.Set, tt.want) {
+				t.Errorf("mutationsFor() set %v, want %v", changes.Labels.Set, tt.want)
+			}
+		})
+	}
+}
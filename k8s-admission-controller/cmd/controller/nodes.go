@@ -0,0 +1,74 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// defaultNodeLabels are the Node labels copied onto scheduled pods when the
+// config does not list any
+var defaultNodeLabels = []string{
+	corev1.LabelTopologyZone,
+	corev1.LabelTopologyRegion,
+	corev1.LabelInstanceTypeStable,
+}
+
+func validateNodeLabels(keys []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, key := range keys {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path.Index(i), key, msg))
+		}
+		if key == missingLabelsValuesLabel {
+			errs = append(errs, field.Forbidden(path.Index(i), "label is managed by the webhook"))
+		}
+	}
+	return errs
+}
+
+// nodeLabelKeys returns the Node labels copied onto scheduled pods
+func (c *Config) nodeLabelKeys() []string {
+	if c.NodeLabels == nil {
+		return defaultNodeLabels
+	}
+	return c.NodeLabels
+}
+
+// nodeLabelsFor returns the configured labels of the Node a pod is scheduled
+// on. It is empty while the pod is unscheduled or the Node is not cached.
+func (c *Config) nodeLabelsFor(pod *corev1.Pod) map[string]string {
+	labels := map[string]string{}
+
+	node := lookupNode(pod.Spec.NodeName)
+	if node == nil {
+		return labels
+	}
+	for _, key := range c.nodeLabelKeys() {
+		if value, ok := node.Labels[key]; ok {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// nodeLister reads Nodes from the shared informer cache. It is nil when the
+// webhook runs outside a cluster.
+var nodeLister corelisters.NodeLister
+
+// lookupNode returns the Node from the informer cache, or nil when it is not
+// cached
+func lookupNode(name string) *corev1.Node {
+	if nodeLister == nil || name == "" {
+		return nil
+	}
+
+	node, err := nodeLister.Get(name)
+	if err != nil {
+		log.WithError(err).WithField("node", name).Debug("Node not found in cache")
+		return nil
+	}
+	return node
+}
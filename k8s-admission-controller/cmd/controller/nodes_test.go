@@ -0,0 +1,69 @@
+package main
+
+import (
+	"maps"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// setTestNodes serves nodes from the Node cache for the duration of the test
+func setTestNodes(t *testing.T, nodes ...*corev1.Node) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := nodeLister
+	nodeLister = corelisters.NewNodeLister(indexer)
+	t.Cleanup(func() { nodeLister = previous })
+}
+
+func TestNodeLabelsFor(t *testing.T) {
+	setTestNodes(t, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node-1",
+		Labels: map[string]string{
+			corev1.LabelTopologyZone:   "eu-west-1a",
+			corev1.LabelTopologyRegion: "eu-west-1",
+			corev1.LabelHostname:       "node-1",
+			"node.example.com/pool":    "general",
+		},
+	}})
+
+	tests := []struct {
+		name       string
+		nodeLabels []string
+		nodeName   string
+		want       map[string]string
+	}{
+		{
+			name:     "default labels missing on the node are skipped",
+			nodeName: "node-1",
+			want:     map[string]string{corev1.LabelTopologyZone: "eu-west-1a", corev1.LabelTopologyRegion: "eu-west-1"},
+		},
+		{
+			name:       "configured labels",
+			nodeLabels: []string{"node.example.com/pool", corev1.LabelHostname},
+			nodeName:   "node-1",
+			want:       map[string]string{"node.example.com/pool": "general", corev1.LabelHostname: "node-1"},
+		},
+		{name: "copy disabled", nodeLabels: []string{}, nodeName: "node-1", want: map[string]string{}},
+		{name: "unscheduled pod", want: map[string]string{}},
+		{name: "node missing from the cache", nodeName: "node-2", want: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{NodeLabels: tt.nodeLabels}
+			pod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: tt.nodeName}}
+			if got := config.nodeLabelsFor(pod); !maps.Equal(got, tt.want) {
+				t.Errorf("nodeLabelsFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// maxOwnerDepth bounds the walk up the ownership chain
+	maxOwnerDepth = 10
+	// ownerLookupTimeout bounds the live lookups of the owners missing from
+	// the cache, for a whole ownership chain
+	ownerLookupTimeout = 2 * time.Second
+	// mapperResetInterval rate-limits the discovery refreshes triggered by
+	// owner kinds unknown to the REST mapper
+	mapperResetInterval = 30 * time.Second
+	ownerResyncPeriod   = 10 * time.Minute
+)
+
+// prewarmedOwners are the owner kinds whose informers start with the webhook
+// because nearly every pod is created through them
+var prewarmedOwners = []schema.GroupVersionResource{
+	{Group: "apps", Version: "v1", Resource: "replicasets"},
+	{Group: "batch", Version: "v1", Resource: "jobs"},
+}
+
+// OwnerConfig maps owner kinds to owningResource label values
+type OwnerConfig struct {
+	// Mappings take precedence over the built-in mappings
+	Mappings []OwnerMapping `json:"mappings,omitempty"`
+	// Fallback is the value of owner kinds without a mapping. The owner kind
+	// itself is used when empty.
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// OwnerMapping maps an owner kind to a label value. An APIGroup of "*"
+// matches any group.
+type OwnerMapping struct {
+	APIGroup string `json:"apiGroup,omitempty"`
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+}
+
+// builtinOwnerMappings recognise the in-tree workload controllers and common
+// custom controllers. Keep them in sync with DefaultOwnerKindMappings in
+// operators/pod-labels-operator/internal/controller/owner.go.
+var builtinOwnerMappings = []OwnerMapping{
+	{APIGroup: "apps", Kind: "Deployment", Value: "Deployment"},
+	{APIGroup: "apps", Kind: "ReplicaSet", Value: "ReplicaSet"},
+	{APIGroup: "apps", Kind: "StatefulSet", Value: "StatefulSet"},
+	{APIGroup: "apps", Kind: "DaemonSet", Value: "DaemonSet"},
+	{APIGroup: "batch", Kind: "CronJob", Value: "CronJob"},
+	{APIGroup: "batch", Kind: "Job", Value: "Job"},
+	{APIGroup: "", Kind: "ReplicationController", Value: "ReplicationController"},
+	{APIGroup: "", Kind: "Node", Value: "Node"},
+	{APIGroup: "argoproj.io", Kind: "Rollout", Value: "Rollout"},
+	{APIGroup: "kubevirt.io", Kind: "VirtualMachine", Value: "VirtualMachine"},
+	{APIGroup: "kubevirt.io", Kind: "VirtualMachineInstance", Value: "VirtualMachineInstance"},
+}
+
+func (c *OwnerConfig) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, mapping := range c.Mappings {
+		if mapping.Kind == "" {
+			errs = append(errs, field.Required(path.Child("mappings").Index(i).Child("kind"), "kind is required"))
+		}
+		for _, msg := range validation.IsValidLabelValue(mapping.Value) {
+			errs = append(errs, field.Invalid(path.Child("mappings").Index(i).Child("value"), mapping.Value, msg))
+		}
+	}
+	for _, msg := range validation.IsValidLabelValue(c.Fallback) {
+		errs = append(errs, field.Invalid(path.Child("fallback"), c.Fallback, msg))
+	}
+	return errs
+}
+
+// valueFor returns the owningResource label value of owner
+func (c *OwnerConfig) valueFor(owner *ownerInfo) string {
+	group := owner.group()
+	for _, mappings := range [][]OwnerMapping{c.Mappings, builtinOwnerMappings} {
+		for _, mapping := range mappings {
+			if (mapping.APIGroup == wildcard || mapping.APIGroup == group) && mapping.Kind == owner.Kind {
+				return mapping.Value
+			}
+		}
+	}
+
+	if c.Fallback != "" {
+		return c.Fallback
+	}
+	return owner.Kind
+}
+
+// ownerInfo identifies the top-level owner of an object
+type ownerInfo struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+func (o *ownerInfo) group() string {
+	gv, _ := schema.ParseGroupVersion(o.APIVersion)
+	return gv.Group
+}
+
+// ownerResolver walks ownership chains using metadata-only informers, which
+// are started lazily for every owner kind encountered
+type ownerResolver struct {
+	client  metadata.Interface
+	mapper  meta.ResettableRESTMapper
+	factory metadatainformer.SharedInformerFactory
+	stopCh  <-chan struct{}
+
+	resetMu   sync.Mutex
+	lastReset time.Time
+}
+
+func newOwnerResolver(config *rest.Config, stopCh <-chan struct{}) (*ownerResolver, error) {
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata client: %v", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %v", err)
+	}
+
+	resolver := &ownerResolver{
+		client:  client,
+		mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		factory: metadatainformer.NewSharedInformerFactory(client, ownerResyncPeriod),
+		stopCh:  stopCh,
+	}
+
+	for _, gvr := range prewarmedOwners {
+		resolver.factory.ForResource(gvr)
+	}
+	resolver.factory.Start(stopCh)
+
+	return resolver, nil
+}
+
+// topLevelOwner follows the owner references of an object in namespace up to
+// the outermost owner. It returns nil when the object has no owner. When an
+// owner cannot be looked up, the last owner found is returned.
+func (r *ownerResolver) topLevelOwner(ctx context.Context, namespace string, refs []metav1.OwnerReference) *ownerInfo {
+	var top *ownerInfo
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref := ownerOf(refs)
+		if ref == nil {
+			break
+		}
+		top = &ownerInfo{APIVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name}
+
+		if r == nil {
+			break
+		}
+
+		owner, err := r.get(ctx, namespace, ref)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"namespace": namespace,
+				"kind":      ref.Kind,
+				"name":      ref.Name,
+			}).Debug("Failed to look up owner, stopping at it")
+			break
+		}
+		refs = owner.GetOwnerReferences()
+	}
+	return top
+}
+
+// ownerOf returns the managing controller, which is the owner reference
+// followed up the chain. Owners that are not controllers are ignored.
+func ownerOf(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// get returns the metadata of an owner from the informer cache, falling back
+// to a live lookup while the cache of its kind is not synced yet
+func (r *ownerResolver) get(ctx context.Context, namespace string, ref *metav1.OwnerReference) (metav1.Object, error) {
+	gvr, namespaced, err := r.resourceFor(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !namespaced {
+		namespace = ""
+	}
+
+	informer := r.factory.ForResource(gvr)
+	r.factory.Start(r.stopCh)
+
+	if informer.Informer().HasSynced() {
+		var obj interface{}
+		if namespaced {
+			obj, err = informer.Lister().ByNamespace(namespace).Get(ref.Name)
+		} else {
+			obj, err = informer.Lister().Get(ref.Name)
+		}
+		if err == nil {
+			return meta.Accessor(obj)
+		}
+	}
+
+	return r.client.Resource(gvr).Namespace(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+}
+
+// resourceFor maps an owner reference to its resource, refreshing discovery
+// for kinds installed after the webhook started. Refreshes are rate-limited,
+// so unknown kinds do not trigger a discovery of the cluster per request.
+func (r *ownerResolver) resourceFor(ref *metav1.OwnerReference) (schema.GroupVersionResource, bool, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("invalid owner apiVersion %q: %v", ref.APIVersion, err)
+	}
+
+	gk := schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+	mapping, err := r.mapper.RESTMapping(gk, gv.Version)
+	if meta.IsNoMatchError(err) && r.resetMapper() {
+		mapping, err = r.mapper.RESTMapping(gk, gv.Version)
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+
+	return mapping.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// resetMapper refreshes the discovery information of the REST mapper, at most
+// once per mapperResetInterval. It reports whether it did.
+func (r *ownerResolver) resetMapper() bool {
+	r.resetMu.Lock()
+	defer r.resetMu.Unlock()
+	if time.Since(r.lastReset) < mapperResetInterval {
+		return false
+	}
+	r.lastReset = time.Now()
+	r.mapper.Reset()
+	return true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/utils/ptr"
+)
+
+// staticMapper serves the REST mappings of a fixed set of kinds
+type staticMapper struct {
+	meta.RESTMapper
+}
+
+func (staticMapper) Reset() {}
+
+// newTestOwnerResolver returns a resolver reading owners through client. Its
+// informers only run until stopCh is closed.
+func newTestOwnerResolver(client metadata.Interface, stopCh <-chan struct{}) *ownerResolver {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1", Kind: "ReplicaSet"},
+		{Group: "batch", Version: "v1", Kind: "CronJob"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	return &ownerResolver{
+		client:  client,
+		mapper:  staticMapper{mapper},
+		factory: metadatainformer.NewSharedInformerFactory(client, 0),
+		stopCh:  stopCh,
+	}
+}
+
+// testOwner returns the metadata of an object owned by controller, if any
+func testOwner(apiVersion, kind, name string, controller *metav1.OwnerReference) *metav1.PartialObjectMetadata {
+	owner := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name},
+	}
+	if controller != nil {
+		owner.OwnerReferences = []metav1.OwnerReference{*controller}
+	}
+	return owner
+}
+
+func controllerRef(apiVersion, kind, name string) *metav1.OwnerReference {
+	return &metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, Controller: ptr.To(true)}
+}
+
+func newTestMetadataClient(t *testing.T) *metadatafake.FakeMetadataClient {
+	t.Helper()
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return metadatafake.NewSimpleMetadataClient(scheme, []runtime.Object{
+		testOwner("apps/v1", "Deployment", "web", nil),
+		testOwner("apps/v1", "ReplicaSet", "web-5d8f", controllerRef("apps/v1", "Deployment", "web")),
+		testOwner("batch/v1", "Job", "backup-2901", controllerRef("batch/v1", "CronJob", "backup")),
+	}...)
+}
+
+func TestTopLevelOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		refs []metav1.OwnerReference
+		want *ownerInfo
+	}{
+		{name: "no owner"},
+		{
+			name: "owner that is not a controller",
+			refs: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f"}},
+		},
+		{
+			name: "ownership chain",
+			refs: []metav1.OwnerReference{*controllerRef("apps/v1", "ReplicaSet", "web-5d8f")},
+			want: &ownerInfo{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+		},
+		{
+			name: "missing owner stops the walk",
+			refs: []metav1.OwnerReference{*controllerRef("batch/v1", "Job", "backup-2901")},
+			want: &ownerInfo{APIVersion: "batch/v1", Kind: "CronJob", Name: "backup"},
+		},
+		{
+			name: "unknown owner kind stops the walk",
+			refs: []metav1.OwnerReference{*controllerRef("argoproj.io/v1alpha1", "Rollout", "web")},
+			want: &ownerInfo{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "web"},
+		},
+	}
+
+	// The informers never sync, so every owner is read from the API
+	stopCh := make(chan struct{})
+	close(stopCh)
+	resolver := newTestOwnerResolver(newTestMetadataClient(t), stopCh)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolver.topLevelOwner(context.Background(), "team-a", tt.refs)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("topLevelOwner() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopLevelOwnerCache(t *testing.T) {
+	client := newTestMetadataClient(t)
+	refs := []metav1.OwnerReference{*controllerRef("apps/v1", "ReplicaSet", "web-5d8f")}
+	want := ownerInfo{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	resolver := newTestOwnerResolver(client, stopCh)
+
+	// Cache misses fall back to a GET while the informers sync
+	if got := resolver.topLevelOwner(context.Background(), "team-a", refs); got == nil || *got != want {
+		t.Fatalf("topLevelOwner() = %+v, want %+v", got, want)
+	}
+	if gets := countGets(client); gets != 2 {
+		t.Errorf("topLevelOwner() made %d GET requests before the caches synced, want 2", gets)
+	}
+
+	for gvr, synced := range resolver.factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			t.Fatalf("informer for %s did not sync", gvr)
+		}
+	}
+	client.ClearActions()
+	if got := resolver.topLevelOwner(context.Background(), "team-a", refs); got == nil || *got != want {
+		t.Fatalf("topLevelOwner() = %+v, want %+v", got, want)
+	}
+	if gets := countGets(client); gets != 0 {
+		t.Errorf("topLevelOwner() made %d GET requests once the caches synced, want 0", gets)
+	}
+}
+
+func countGets(client *metadatafake.FakeMetadataClient) int {
+	gets := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" {
+			gets++
+		}
+	}
+	return gets
+}
+
+func TestOwnerValueFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		config OwnerConfig
+		owner  ownerInfo
+		want   string
+	}{
+		{name: "built-in workload", owner: ownerInfo{APIVersion: "apps/v1", Kind: "Deployment"}, want: "Deployment"},
+		{name: "Argo Rollout", owner: ownerInfo{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"}, want: "Rollout"},
+		{name: "KubeVirt VirtualMachine", owner: ownerInfo{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachine"}, want: "VirtualMachine"},
+		{name: "KubeVirt VirtualMachineInstance", owner: ownerInfo{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachineInstance"}, want: "VirtualMachineInstance"},
+		{name: "kind of another group", owner: ownerInfo{APIVersion: "example.com/v1", Kind: "Rollout"}, want: "Rollout"},
+		{
+			name:   "mapping overrides built-in",
+			config: OwnerConfig{Mappings: []OwnerMapping{{APIGroup: "argoproj.io", Kind: "Rollout", Value: "ArgoRollout"}}},
+			owner:  ownerInfo{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"},
+			want:   "ArgoRollout",
+		},
+		{
+			name:   "mapping of any group",
+			config: OwnerConfig{Mappings: []OwnerMapping{{APIGroup: wildcard, Kind: "Workflow", Value: "Workflow"}}},
+			owner:  ownerInfo{APIVersion: "argoproj.io/v1alpha1", Kind: "Workflow"},
+			want:   "Workflow",
+		},
+		{
+			name:   "fallback",
+			config: OwnerConfig{Fallback: "Other"},
+			owner:  ownerInfo{APIVersion: "example.com/v1", Kind: "Widget"},
+			want:   "Other",
+		},
+		{name: "owner kind without fallback", owner: ownerInfo{APIVersion: "example.com/v1", Kind: "Widget"}, want: "Widget"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.valueFor(&tt.owner); got != tt.want {
+				t.Errorf("valueFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// JSON Patch operations
+const (
+	patchOpAdd     = "add"
+	patchOpRemove  = "remove"
+	patchOpReplace = "replace"
+)
+
+// patchOperation is a single RFC 6902 JSON Patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// pointerEscaper escapes a reference token of a JSON Pointer as defined by
+// RFC 6901, so that keys such as app.kubernetes.io/name are addressable
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// pointerTo joins the escaped segments onto a JSON Pointer
+func pointerTo(base string, segments ...string) string {
+	var pointer strings.Builder
+	pointer.WriteString(base)
+	for _, segment := range segments {
+		pointer.WriteString("/")
+		pointer.WriteString(pointerEscaper.Replace(segment))
+	}
+	return pointer.String()
+}
+
+// diffPatch returns the operations turning original into mutated. Maps are
+// compared key by key, any other value that differs is replaced as a whole,
+// and values that are unchanged produce no operation.
+func diffPatch(path string, original, mutated map[string]interface{}) []patchOperation {
+	var operations []patchOperation
+
+	for _, key := range sortedKeys(original) {
+		if _, ok := mutated[key]; !ok {
+			operations = append(operations, patchOperation{Op: patchOpRemove, Path: pointerTo(path, key)})
+		}
+	}
+
+	for _, key := range sortedKeys(mutated) {
+		value := mutated[key]
+		current, ok := original[key]
+		if !ok {
+			operations = append(operations, patchOperation{Op: patchOpAdd, Path: pointerTo(path, key), Value: value})
+			continue
+		}
+
+		currentMap, currentIsMap := current.(map[string]interface{})
+		valueMap, valueIsMap := value.(map[string]interface{})
+		if currentIsMap && valueIsMap {
+			operations = append(operations, diffPatch(pointerTo(path, key), currentMap, valueMap)...)
+		} else if !reflect.DeepEqual(current, value) {
+			operations = append(operations, patchOperation{Op: patchOpReplace, Path: pointerTo(path, key), Value: value})
+		}
+	}
+
+	return operations
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apply returns a copy of obj with the mutations applied to the target
+// metadata. Label and annotation maps are only created when something is
+// set in them.
+func (m *mutations) apply(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	mutated := obj.DeepCopy()
+	if !m.target.writable {
+		return mutated, nil
+	}
+
+	for _, metadata := range []struct {
+		field   string
+		current map[string]string
+		changes metadataChanges
+	}{
+		{"labels", m.target.labels, m.Labels},
+		{"annotations", m.target.annotations, m.Annotations},
+	} {
+		values := make(map[string]string, len(metadata.current)+len(metadata.changes.Set))
+		for key, value := range metadata.current {
+			values[key] = value
+		}
+		for key, value := range metadata.changes.Set {
+			values[key] = value
+		}
+		for _, key := range metadata.changes.Remove {
+			delete(values, key)
+		}
+
+		if metadata.current == nil && len(values) == 0 {
+			continue
+		}
+		fields := append(append([]string{}, m.target.fields...), metadata.field)
+		if err := unstructured.SetNestedStringMap(mutated.Object, values, fields...); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %v", strings.Join(fields, "."), err)
+		}
+	}
+
+	return mutated, nil
+}
+
+// marshalPatch encodes the operations, returning nil when there are none
+func marshalPatch(operations []patchOperation) ([]byte, error) {
+	if len(operations) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(operations)
+}
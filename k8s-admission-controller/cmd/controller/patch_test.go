@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPointerTo(t *testing.T) {
+	tests := []struct {
+		base     string
+		segments []string
+		want     string
+	}{
+		{base: "", segments: []string{"metadata", "labels", "team"}, want: "/metadata/labels/team"},
+		{base: "/metadata/labels", segments: []string{"app.kubernetes.io/name"}, want: "/metadata/labels/app.kubernetes.io~1name"},
+		{base: "/metadata/labels", segments: []string{"a~b"}, want: "/metadata/labels/a~0b"},
+		{base: "/metadata/labels", segments: []string{"~1"}, want: "/metadata/labels/~01"},
+		{base: "/metadata/annotations", segments: []string{`say "hi"`}, want: `/metadata/annotations/say "hi"`},
+		{base: "/metadata/labels", segments: []string{""}, want: "/metadata/labels/"},
+		{base: "/metadata", segments: nil, want: "/metadata"},
+	}
+
+	for _, tt := range tests {
+		if got := pointerTo(tt.base, tt.segments...); got != tt.want {
+			t.Errorf("pointerTo(%q, %q) = %q, want %q", tt.base, tt.segments, got, tt.want)
+		}
+	}
+}
+
+func TestDiffPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original map[string]interface{}
+		mutated  map[string]interface{}
+		want     []patchOperation
+	}{
+		{
+			name:     "unchanged",
+			original: map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+			mutated:  map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+		},
+		{
+			name:     "escaped keys",
+			original: map[string]interface{}{"labels": map[string]interface{}{"a~b": "x", "old/key": "y"}},
+			mutated:  map[string]interface{}{"labels": map[string]interface{}{"a~b": "z", "app.kubernetes.io/name": "web"}},
+			want: []patchOperation{
+				{Op: patchOpRemove, Path: "/metadata/labels/old~1key"},
+				{Op: patchOpAdd, Path: "/metadata/labels/app.kubernetes.io~1name", Value: "web"},
+				{Op: patchOpReplace, Path: "/metadata/labels/a~0b", Value: "z"},
+			},
+		},
+		{
+			name:     "quoted annotation value",
+			original: map[string]interface{}{"annotations": map[string]interface{}{}},
+			mutated:  map[string]interface{}{"annotations": map[string]interface{}{"note": `say "hi"`}},
+			want:     []patchOperation{{Op: patchOpAdd, Path: "/metadata/annotations/note", Value: `say "hi"`}},
+		},
+		{
+			name:     "missing labels map",
+			original: map[string]interface{}{"name": "web-0"},
+			mutated:  map[string]interface{}{"name": "web-0", "labels": map[string]interface{}{"team": "a"}},
+			want:     []patchOperation{{Op: patchOpAdd, Path: "/metadata/labels", Value: map[string]interface{}{"team": "a"}}},
+		},
+		{
+			name:     "nil labels map",
+			original: map[string]interface{}{"labels": nil},
+			mutated:  map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+			want:     []patchOperation{{Op: patchOpReplace, Path: "/metadata/labels", Value: map[string]interface{}{"team": "a"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffPatch("/metadata", tt.original, tt.mutated); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffPatch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutationsApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]interface{}
+		set    map[string]string
+		want   []patchOperation
+	}{
+		{
+			name: "nil labels map is created",
+			set:  map[string]string{"app.kubernetes.io/name": "web"},
+			want: []patchOperation{{Op: patchOpAdd, Path: "/metadata/labels", Value: map[string]interface{}{"app.kubernetes.io/name": "web"}}},
+		},
+		{
+			name:   "existing labels are patched by key",
+			labels: map[string]interface{}{"app": "web"},
+			set:    map[string]string{"app.kubernetes.io/name": "web"},
+			want:   []patchOperation{{Op: patchOpAdd, Path: "/metadata/labels/app.kubernetes.io~1name", Value: "web"}},
+		},
+		{
+			name: "nothing to set leaves the labels map out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod()
+			pod.Object["metadata"] = map[string]interface{}{"name": "web-0", "namespace": "team-a"}
+			if tt.labels != nil {
+				pod.Object["metadata"].(map[string]interface{})["labels"] = tt.labels
+			}
+			changes := newMutations((&Config{}).metadataTargetFor(pod, podGroupKind))
+			for key, value := range tt.set {
+				changes.Labels.Set[key] = value
+			}
+
+			mutated, err := changes.apply(pod)
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			original := pod.Object["metadata"].(map[string]interface{})
+			if got := diffPatch("/metadata", original, mutated.Object["metadata"].(map[string]interface{})); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffPatch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// podLabelRetries bounds the attempts to label a pod before it is dropped
+	// until its next update
+	podLabelRetries = 5
+	// podPatchTimeout bounds a single patch of a pod
+	podPatchTimeout = 10 * time.Second
+)
+
+// podLabeler applies the labels that depend on scheduling data to pods once
+// they have an IP address and a node. Only pods still marked with the
+// missingLabelsValues label are watched, so labelled pods leave the cache.
+type podLabeler struct {
+	client   kubernetes.Interface
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   corelisters.PodLister
+	queue    workqueue.TypedRateLimitingInterface[string]
+	workers  int
+}
+
+func newPodLabeler(client kubernetes.Interface, workers int) (*podLabeler, error) {
+	selector := labels.SelectorFromSet(labels.Set{missingLabelsValuesLabel: "true"}).String()
+	factory := informers.NewSharedInformerFactoryWithOptions(client, informerResyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}))
+	podInformer := factory.Core().V1().Pods()
+
+	labeler := &podLabeler{
+		client:   client,
+		factory:  factory,
+		informer: podInformer.Informer(),
+		lister:   podInformer.Lister(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "pod-labels"},
+		),
+		workers: workers,
+	}
+
+	_, err := labeler.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: labeler.enqueue,
+		UpdateFunc: func(_, obj interface{}) {
+			labeler.enqueue(obj)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pods: %v", err)
+	}
+
+	return labeler, nil
+}
+
+// enqueue schedules a pod for labelling. Repeated updates of a pod waiting
+// in the queue are collapsed into a single item.
+func (l *podLabeler) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	l.queue.Add(key)
+	podLabelQueueDepth.Set(float64(l.queue.Len()))
+}
+
+// run starts the pod informer and the workers, and blocks until stopCh is
+// closed and the workers have labelled the pods left in the queue. Retries
+// are not scheduled once stopCh is closed.
+func (l *podLabeler) run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer l.queue.ShutDown()
+
+	l.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, l.informer.HasSynced) {
+		log.Error("Failed to sync pod cache")
+		return
+	}
+
+	log.WithField("workers", l.workers).Info("Starting pod labeler")
+	var workers wait.Group
+	for i := 0; i < l.workers; i++ {
+		workers.Start(func() {
+			wait.Until(l.runWorker, time.Second, stopCh)
+		})
+	}
+
+	<-stopCh
+	log.WithField("queued", l.queue.Len()).Info("Stopping pod labeler")
+	l.queue.ShutDown()
+	workers.Wait()
+}
+
+func (l *podLabeler) runWorker() {
+	for l.processNextItem() {
+	}
+}
+
+func (l *podLabeler) processNextItem() bool {
+	key, shutdown := l.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer l.queue.Done(key)
+	podLabelQueueDepth.Set(float64(l.queue.Len()))
+
+	err := l.sync(key)
+	switch {
+	case err == nil:
+		l.queue.Forget(key)
+	case l.queue.NumRequeues(key) < podLabelRetries:
+		log.WithError(err).WithField("pod", key).Warn("Failed to label pod, retrying")
+		podLabelResults.WithLabelValues("retried").Inc()
+		l.queue.AddRateLimited(key)
+	default:
+		log.WithError(err).WithField("pod", key).Error("Failed to label pod, dropping it until its next update")
+		podLabelResults.WithLabelValues("dropped").Inc()
+		l.queue.Forget(key)
+	}
+	return true
+}
+
+// sync labels the pod identified by key once it is scheduled and running.
+// Pods that were deleted, or are still waiting for an IP address or node, are
+// skipped.
+func (l *podLabeler) sync(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	pod, err := l.lister.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.Status.PodIP == "" || pod.Spec.NodeName == "" {
+		return nil
+	}
+
+	ctx, span := tracer.Start(context.Background(), "label pod", trace.WithAttributes(podAttributes(pod)...))
+	err = l.labelPod(ctx, pod)
+	endSpan(span, err)
+	return err
+}
+
+// labelPod patches a pod with the labels and annotations that depend on
+// scheduling data. The patch carries the pod's UID, so it fails rather than
+// labelling a different pod that reused the name.
+func (l *podLabeler) labelPod(ctx context.Context, pod *corev1.Pod) error {
+	changes, err := rules.Load().Config.deferredMutationsFor(ctx, pod)
+	if err != nil {
+		return err
+	}
+	labels := changes.Labels.Set
+	labels[missingLabelsValuesLabel] = "false"
+
+	metadata := map[string]interface{}{
+		"uid":    pod.UID,
+		"labels": labels,
+	}
+	if len(changes.Annotations.Set) > 0 {
+		metadata["annotations"] = changes.Annotations.Set
+	}
+
+	patchData, err := json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch data: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, podPatchTimeout)
+	defer cancel()
+	_, err = l.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patchData, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		// The pod was deleted, or replaced by a pod with the same name
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to patch pod: %v", err)
+	}
+	podLabelResults.WithLabelValues("labelled").Inc()
+	timeToLabel.Observe(time.Since(pod.CreationTimestamp.Time).Seconds())
+
+	log.WithFields(log.Fields{
+		"namespace": pod.Namespace,
+		"name":      pod.Name,
+		"uid":       pod.UID,
+		"labels":    labels,
+	}).Info("Successfully patched pod labels")
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// scheduledPod returns a pod waiting for its scheduling labels that has an
+// IP address and a node
+func scheduledPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "team-a",
+			Name:      "web-0",
+			UID:       "0f6f8f3e",
+			Labels:    map[string]string{missingLabelsValuesLabel: "true"},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{PodIP: "10.0.0.12"},
+	}
+}
+
+// newTestPodLabeler returns a labeler whose cache and API server hold pods
+func newTestPodLabeler(t *testing.T, pods ...*corev1.Pod) *podLabeler {
+	t.Helper()
+	objects := make([]runtime.Object, 0, len(pods))
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	labeler, err := newPodLabeler(fake.NewClientset(objects...), 1)
+	if err != nil {
+		t.Fatalf("newPodLabeler() error = %v", err)
+	}
+	for _, pod := range pods {
+		if err := labeler.informer.GetIndexer().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(labeler.queue.ShutDown)
+	return labeler
+}
+
+// patches returns the patches sent by the labeler
+func patches(labeler *podLabeler) []k8stesting.PatchAction {
+	var result []k8stesting.PatchAction
+	for _, action := range labeler.client.(*fake.Clientset).Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			result = append(result, patch)
+		}
+	}
+	return result
+}
+
+func TestPodLabelerSync(t *testing.T) {
+	unscheduled := scheduledPod()
+	unscheduled.Status.PodIP = ""
+
+	tests := []struct {
+		name        string
+		cached      *corev1.Pod
+		patchErr    error
+		wantErr     bool
+		wantPatches int
+	}{
+		{name: "pod left the cache"},
+		{name: "pod without an IP address", cached: unscheduled},
+		{name: "scheduled pod", cached: scheduledPod(), wantPatches: 1},
+		{name: "deleted pod", cached: scheduledPod(), patchErr: apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "web-0"), wantPatches: 1},
+		{name: "pod replaced under the same name", cached: scheduledPod(), patchErr: apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "web-0", nil), wantPatches: 1},
+		{name: "failed patch", cached: scheduledPod(), patchErr: apierrors.NewInternalError(errors.New("etcdserver: request timed out")), wantErr: true, wantPatches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestRules(t, "nodeLabels: []\nrules:\n  - name: nodeName\n    source: nodeName\n    default: pending\n")
+			var labeler *podLabeler
+			if tt.cached != nil {
+				labeler = newTestPodLabeler(t, tt.cached)
+			} else {
+				labeler = newTestPodLabeler(t)
+			}
+			if tt.patchErr != nil {
+				labeler.client.(*fake.Clientset).PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.patchErr
+				})
+			}
+
+			if err := labeler.sync("team-a/web-0"); (err != nil) != tt.wantErr {
+				t.Fatalf("sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(patches(labeler)); got != tt.wantPatches {
+				t.Fatalf("sync() sent %d patches, want %d", got, tt.wantPatches)
+			}
+		})
+	}
+}
+
+func TestPodLabelerPatch(t *testing.T) {
+	setTestRules(t, "nodeLabels: []\nrules:\n  - name: nodeName\n    source: nodeName\n    default: pending\n")
+	labeler := newTestPodLabeler(t, scheduledPod())
+	if err := labeler.sync("team-a/web-0"); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	var patch struct {
+		Metadata struct {
+			UID    string            `json:"uid"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patches(labeler)[0].GetPatch(), &patch); err != nil {
+		t.Fatal(err)
+	}
+	// The UID makes the API server reject the patch of a pod that reused the name
+	if patch.Metadata.UID != "0f6f8f3e" {
+		t.Errorf("patch uid = %q, want the uid of the pod", patch.Metadata.UID)
+	}
+	if got := patch.Metadata.Labels; got["nodeName"] != "node-1" || got[missingLabelsValuesLabel] != "false" {
+		t.Errorf("patch labels = %v, want nodeName=node-1 and %s=false", got, missingLabelsValuesLabel)
+	}
+}
+
+func TestPodLabelerRetries(t *testing.T) {
+	setTestRules(t, "nodeLabels: []\nrules:\n  - name: nodeName\n    source: nodeName\n    default: pending\n")
+	labeler := newTestPodLabeler(t, scheduledPod())
+	labeler.client.(*fake.Clientset).PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewInternalError(errors.New("etcdserver: request timed out"))
+	})
+
+	labeler.enqueue(scheduledPod())
+	for i := 0; i <= podLabelRetries; i++ {
+		labeler.processNextItem()
+	}
+
+	if got := len(patches(labeler)); got != podLabelRetries+1 {
+		t.Errorf("labeler sent %d patches, want %d", got, podLabelRetries+1)
+	}
+	if labeler.queue.Len() != 0 || labeler.queue.NumRequeues("team-a/web-0") != 0 {
+		t.Error("labeler did not drop the pod after its last retry")
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+)
+
+// Paths of the admission endpoints, shared by the server and the webhook
+// configurations it registers
+const (
+	mutatePath            = "/mutate"
+	mutatePodCreationPath = "/mutate-pod-creation"
+	validatePath          = "/validate"
+	// validatePodStatusPath is no longer registered. It allows every request
+	// for the webhook configurations still calling it.
+	validatePodStatusPath = "/validate-pod-status"
+)
+
+const (
+	defaultWebhookTimeoutSeconds = 5
+	// registrationTimeout bounds the registration and removal of the webhook
+	// configurations
+	registrationTimeout = 30 * time.Second
+	// managedByLabel marks the webhook configurations registered by the
+	// webhook itself
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+var (
+	supportedFailurePolicies = sets.New(string(admissionregistrationv1.Fail), string(admissionregistrationv1.Ignore))
+	// defaultExcludedNamespaces mirror the namespace selectors of
+	// manifests/webhooks
+	defaultExcludedNamespaces = []string{metav1.NamespaceSystem, "cert-manager", "pod-labels-operator-system"}
+)
+
+// RegistrationConfig shapes the webhook configurations registered by the
+// webhook itself with --register-webhooks
+type RegistrationConfig struct {
+	// ExcludedNamespaces are never sent to the webhook. kube-system,
+	// cert-manager and the operator's Namespace are excluded when nil.
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// TimeoutSeconds bounds each call of the API server, 5 when empty
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// FailurePolicy of the pod webhooks, Fail when empty. Pod templates are
+	// always mutated with the Ignore policy, as their pods are mutated anyway.
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// Annotations are set on the webhook configurations, e.g.
+	// cert-manager.io/inject-ca-from
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func (c *RegistrationConfig) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if c.TimeoutSeconds < 0 || c.TimeoutSeconds > 30 {
+		errs = append(errs, field.Invalid(path.Child("timeoutSeconds"), c.TimeoutSeconds, "must be between 1 and 30"))
+	}
+	if c.FailurePolicy != "" && !supportedFailurePolicies.Has(c.FailurePolicy) {
+		errs = append(errs, field.NotSupported(path.Child("failurePolicy"), c.FailurePolicy, sets.List(supportedFailurePolicies)))
+	}
+	for i, namespace := range c.ExcludedNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(path.Child("excludedNamespaces").Index(i), namespace, msg))
+		}
+	}
+	for key := range c.Annotations {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path.Child("annotations").Key(key), key, msg))
+		}
+	}
+	return errs
+}
+
+// webhookRegistrar creates, updates and deletes the webhook configurations
+// calling the Service of the webhook
+type webhookRegistrar struct {
+	client  kubernetes.Interface
+	webhook webhookOptions
+}
+
+func newWebhookRegistrar(config *rest.Config, webhook webhookOptions) (*webhookRegistrar, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	return &webhookRegistrar{client: clientset, webhook: webhook}, nil
+}
+
+// register creates or updates the webhook configurations for config. The
+// workload webhook and the validating webhook cover the kinds selected by
+// the rules and required labels, among the resources served by the cluster.
+// Existing annotations are kept, as are existing CA bundles when caBundle
+// is empty, so that injected CA bundles survive.
+func (r *webhookRegistrar) register(ctx context.Context, config *Config, caBundle []byte) error {
+	resources, err := r.client.Discovery().ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return fmt.Errorf("failed to discover API resources: %v", err)
+	}
+	if err != nil {
+		log.WithError(err).Warn("Registering webhooks for the API groups discovered")
+	}
+
+	var workloadSelectors, requiredSelectors []ResourceSelector
+	for _, rule := range config.Rules {
+		workloadSelectors = append(workloadSelectors, rule.Resources...)
+	}
+	for _, requirement := range config.RequiredLabels {
+		requiredSelectors = append(requiredSelectors, requirement.Resources...)
+	}
+	mutating := r.mutatingConfiguration(&config.Registration, resourceRules(resources, config, workloadSelectors, true), caBundle)
+	if err := r.applyMutating(ctx, mutating); err != nil {
+		return err
+	}
+	validating := r.validatingConfiguration(&config.Registration, resourceRules(resources, config, requiredSelectors, false), caBundle)
+	if err := r.applyValidating(ctx, validating); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"mutatingWebhookConfiguration":   mutating.Name,
+		"mutatingWebhooks":               len(mutating.Webhooks),
+		"validatingWebhookConfiguration": validating.Name,
+		"validatingWebhooks":             len(validating.Webhooks),
+	}).Info("Registered webhook configurations")
+	return nil
+}
+
+// unregister deletes the webhook configurations registered by the webhook,
+// ignoring missing ones. Configurations not labelled as managed by the
+// webhook, e.g. applied from manifests/webhooks, are left in place.
+func (r *webhookRegistrar) unregister(ctx context.Context) error {
+	mutating := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	existing, err := mutating.Get(ctx, r.webhook.mutatingConfig, metav1.GetOptions{})
+	err = r.deleteManaged("MutatingWebhookConfiguration", r.webhook.mutatingConfig, existing, err, func(options metav1.DeleteOptions) error {
+		return mutating.Delete(ctx, r.webhook.mutatingConfig, options)
+	})
+	if err != nil {
+		return err
+	}
+
+	validating := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	existingValidating, err := validating.Get(ctx, r.webhook.validatingConfig, metav1.GetOptions{})
+	return r.deleteManaged("ValidatingWebhookConfiguration", r.webhook.validatingConfig, existingValidating, err, func(options metav1.DeleteOptions) error {
+		return validating.Delete(ctx, r.webhook.validatingConfig, options)
+	})
+}
+
+// deleteManaged deletes the configuration read as existing, with getErr,
+// when it carries the managed-by label of the webhook
+func (r *webhookRegistrar) deleteManaged(kind, name string, existing metav1.Object, getErr error, deleteFunc func(metav1.DeleteOptions) error) error {
+	if apierrors.IsNotFound(getErr) {
+		return nil
+	}
+	if getErr != nil {
+		return fmt.Errorf("failed to get %s %s: %v", kind, name, getErr)
+	}
+
+	logger := log.WithField(kind, name)
+	if managedBy := existing.GetLabels()[managedByLabel]; managedBy != r.webhook.serviceName {
+		logger.WithField(managedByLabel, managedBy).Warn("Skipping webhook configuration not registered by the webhook")
+		return nil
+	}
+
+	// The preconditions spare a configuration replaced in the meantime
+	err := deleteFunc(metav1.DeleteOptions{Preconditions: &metav1.Preconditions{
+		UID:             ptr.To(existing.GetUID()),
+		ResourceVersion: ptr.To(existing.GetResourceVersion()),
+	}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s %s: %v", kind, name, err)
+	}
+	logger.Info("Deleted webhook configuration")
+	return nil
+}
+
+// mutatingConfiguration labels pods on creation and, when rules select other
+// kinds, the pod templates of workloads
+func (r *webhookRegistrar) mutatingConfiguration(config *RegistrationConfig, workloadRules []admissionregistrationv1.RuleWithOperations, caBundle []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	webhooks := []admissionregistrationv1.MutatingWebhook{{
+		Name:                    r.webhookName("pod-creation-webhook"),
+		ClientConfig:            r.clientConfig(mutatePodCreationPath, caBundle),
+		Rules:                   []admissionregistrationv1.RuleWithOperations{podRule(admissionregistrationv1.Create)},
+		FailurePolicy:           failurePolicy(config),
+		MatchPolicy:             ptr.To(admissionregistrationv1.Equivalent),
+		NamespaceSelector:       r.namespaceSelector(config),
+		ObjectSelector:          r.objectSelector(),
+		SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+		TimeoutSeconds:          timeoutSeconds(config),
+		AdmissionReviewVersions: []string{admissionv1.SchemeGroupVersion.Version},
+		ReinvocationPolicy:      ptr.To(admissionregistrationv1.NeverReinvocationPolicy),
+	}}
+	if len(workloadRules) > 0 {
+		webhooks = append(webhooks, admissionregistrationv1.MutatingWebhook{
+			Name:                    r.webhookName("workload-labels-webhook"),
+			ClientConfig:            r.clientConfig(mutatePath, caBundle),
+			Rules:                   workloadRules,
+			FailurePolicy:           ptr.To(admissionregistrationv1.Ignore),
+			MatchPolicy:             ptr.To(admissionregistrationv1.Equivalent),
+			NamespaceSelector:       r.namespaceSelector(config),
+			ObjectSelector:          r.objectSelector(),
+			SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+			TimeoutSeconds:          timeoutSeconds(config),
+			AdmissionReviewVersions: []string{admissionv1.SchemeGroupVersion.Version},
+			ReinvocationPolicy:      ptr.To(admissionregistrationv1.NeverReinvocationPolicy),
+		})
+	}
+
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: r.objectMeta(r.webhook.mutatingConfig, config),
+		Webhooks:   webhooks,
+	}
+}
+
+// validatingConfiguration checks the required labels of pods and of the
+// kinds selected by requirements
+func (r *webhookRegistrar) validatingConfiguration(config *RegistrationConfig, requiredRules []admissionregistrationv1.RuleWithOperations, caBundle []byte) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: r.objectMeta(r.webhook.validatingConfig, config),
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    r.webhookName("pod-labels-validator"),
+			ClientConfig:            r.clientConfig(validatePath, caBundle),
+			Rules:                   append([]admissionregistrationv1.RuleWithOperations{podRule(admissionregistrationv1.Create, admissionregistrationv1.Update)}, requiredRules...),
+			FailurePolicy:           failurePolicy(config),
+			MatchPolicy:             ptr.To(admissionregistrationv1.Equivalent),
+			NamespaceSelector:       r.namespaceSelector(config),
+			ObjectSelector:          r.objectSelector(),
+			SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+			TimeoutSeconds:          timeoutSeconds(config),
+			AdmissionReviewVersions: []string{admissionv1.SchemeGroupVersion.Version},
+		}},
+	}
+}
+
+func (r *webhookRegistrar) applyMutating(ctx context.Context, desired *admissionregistrationv1.MutatingWebhookConfiguration) error {
+	configs := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := configs.Get(ctx, desired.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configs.Create(ctx, desired, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		bundles := map[string][]byte{}
+		for _, webhook := range existing.Webhooks {
+			bundles[webhook.Name] = webhook.ClientConfig.CABundle
+		}
+		for i := range desired.Webhooks {
+			keepCABundle(&desired.Webhooks[i].ClientConfig, bundles[desired.Webhooks[i].Name])
+		}
+		desired.ObjectMeta = mergeObjectMeta(existing.ObjectMeta, desired.ObjectMeta)
+		_, err = configs.Update(ctx, desired, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register MutatingWebhookConfiguration %s: %v", desired.Name, err)
+	}
+	return nil
+}
+
+func (r *webhookRegistrar) applyValidating(ctx context.Context, desired *admissionregistrationv1.ValidatingWebhookConfiguration) error {
+	configs := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := configs.Get(ctx, desired.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configs.Create(ctx, desired, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		bundles := map[string][]byte{}
+		for _, webhook := range existing.Webhooks {
+			bundles[webhook.Name] = webhook.ClientConfig.CABundle
+		}
+		for i := range desired.Webhooks {
+			keepCABundle(&desired.Webhooks[i].ClientConfig, bundles[desired.Webhooks[i].Name])
+		}
+		desired.ObjectMeta = mergeObjectMeta(existing.ObjectMeta, desired.ObjectMeta)
+		_, err = configs.Update(ctx, desired, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register ValidatingWebhookConfiguration %s: %v", desired.Name, err)
+	}
+	return nil
+}
+
+// webhookName qualifies name with the DNS name of the Service, as webhook
+// names must be fully qualified
+func (r *webhookRegistrar) webhookName(name string) string {
+	return name + "." + r.webhook.namespace + ".svc.cluster.local"
+}
+
+func (r *webhookRegistrar) clientConfig(path string, caBundle []byte) admissionregistrationv1.WebhookClientConfig {
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: r.webhook.namespace,
+			Name:      r.webhook.serviceName,
+			Path:      ptr.To(path),
+		},
+		CABundle: caBundle,
+	}
+}
+
+// namespaceSelector excludes the configured Namespaces
+func (r *webhookRegistrar) namespaceSelector(config *RegistrationConfig) *metav1.LabelSelector {
+	excluded := config.ExcludedNamespaces
+	if excluded == nil {
+		excluded = defaultExcludedNamespaces
+	}
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      corev1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   excluded,
+		}},
+	}
+}
+
+// objectSelector excludes the pods of the webhook, which could otherwise not
+// be created while no replica is running
+func (r *webhookRegistrar) objectSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      "app",
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{r.webhook.serviceName},
+		}},
+	}
+}
+
+func (r *webhookRegistrar) objectMeta(name string, config *RegistrationConfig) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{managedByLabel: r.webhook.serviceName},
+		Annotations: maps.Clone(config.Annotations),
+	}
+}
+
+// mergeObjectMeta keeps the labels and annotations of existing that desired
+// does not set
+func mergeObjectMeta(existing, desired metav1.ObjectMeta) metav1.ObjectMeta {
+	merged := existing
+	merged.Labels = maps.Clone(existing.Labels)
+	if merged.Labels == nil {
+		merged.Labels = map[string]string{}
+	}
+	maps.Copy(merged.Labels, desired.Labels)
+	merged.Annotations = maps.Clone(existing.Annotations)
+	if merged.Annotations == nil {
+		merged.Annotations = map[string]string{}
+	}
+	maps.Copy(merged.Annotations, desired.Annotations)
+	return merged
+}
+
+// keepCABundle keeps an existing CA bundle when no CA bundle is registered
+func keepCABundle(config *admissionregistrationv1.WebhookClientConfig, existing []byte) {
+	if len(config.CABundle) == 0 {
+		config.CABundle = existing
+	}
+}
+
+func podRule(operations ...admissionregistrationv1.OperationType) admissionregistrationv1.RuleWithOperations {
+	return admissionregistrationv1.RuleWithOperations{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{corev1.GroupName},
+			APIVersions: []string{"v1"},
+			Resources:   []string{"pods"},
+			Scope:       ptr.To(admissionregistrationv1.AllScopes),
+		},
+	}
+}
+
+// resourceRules resolves the kinds matched by selectors to the resources
+// served in their preferred versions, one rule per group version and set of
+// operations. Pods are left to the pod webhooks and subresources are skipped.
+// "*" kind selectors only expand to the workloads with a pod template. The
+// immutable pod templates are only mutated on creation.
+func resourceRules(resources []*metav1.APIResourceList, config *Config, selectors []ResourceSelector, mutate bool) []admissionregistrationv1.RuleWithOperations {
+	var rules []admissionregistrationv1.RuleWithOperations
+	for _, list := range resources {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		var names, createNames []string
+		for _, resource := range list.APIResources {
+			gvk := gv.WithKind(resource.Kind)
+			if strings.Contains(resource.Name, "/") || gvk.GroupKind() == podGroupKind || !config.selectsResource(selectors, gvk) {
+				continue
+			}
+			if template := config.podTemplateFor(gvk.GroupKind()); mutate && template != nil && template.Immutable {
+				createNames = append(createNames, resource.Name)
+			} else {
+				names = append(names, resource.Name)
+			}
+		}
+		rules = appendResourceRule(rules, gv, names, admissionregistrationv1.Create, admissionregistrationv1.Update)
+		rules = appendResourceRule(rules, gv, createNames, admissionregistrationv1.Create)
+	}
+	return rules
+}
+
+// selectsResource reports whether selectors register the webhook for gvk
+func (c *Config) selectsResource(selectors []ResourceSelector, gvk schema.GroupVersionKind) bool {
+	for _, selector := range selectors {
+		if !selector.matches(gvk) {
+			continue
+		}
+		if selector.Kind != wildcard {
+			return true
+		}
+		if c.podTemplateFor(gvk.GroupKind()) != nil && !derivedWorkloads.Has(gvk.GroupKind()) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendResourceRule(rules []admissionregistrationv1.RuleWithOperations, gv schema.GroupVersion, names []string, operations ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	if len(names) == 0 {
+		return rules
+	}
+	return append(rules, admissionregistrationv1.RuleWithOperations{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{gv.Group},
+			APIVersions: []string{gv.Version},
+			Resources:   names,
+			Scope:       ptr.To(admissionregistrationv1.AllScopes),
+		},
+	})
+}
+
+func failurePolicy(config *RegistrationConfig) *admissionregistrationv1.FailurePolicyType {
+	if config.FailurePolicy == "" {
+		return ptr.To(admissionregistrationv1.Fail)
+	}
+	return ptr.To(admissionregistrationv1.FailurePolicyType(config.FailurePolicy))
+}
+
+func timeoutSeconds(config *RegistrationConfig) *int32 {
+	if config.TimeoutSeconds == 0 {
+		return ptr.To(int32(defaultWebhookTimeoutSeconds))
+	}
+	return ptr.To(config.TimeoutSeconds)
+}
+
+// registrationCABundle returns the CA bundle registered with the webhooks:
+// the CA of the bootstrapped Secret, or the ca.crt next to the mounted
+// certificate. It is empty when the CA is injected by cert-manager.
+func registrationCABundle(ctx context.Context, client kubernetes.Interface, webhook webhookOptions, bootstrap certBootstrapOptions) ([]byte, error) {
+	if bootstrap.enabled {
+		secret, err := client.CoreV1().Secrets(webhook.namespace).Get(ctx, bootstrap.secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get certificate Secret: %v", err)
+		}
+		return secret.Data[caCertKey], nil
+	}
+
+	data, err := os.ReadFile(certDir + caCertKey)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testResources mirrors the preferred resources served by a cluster
+var testResources = []*metav1.APIResourceList{
+	{GroupVersion: "v1", APIResources: []metav1.APIResource{
+		{Name: "pods", Kind: "Pod"},
+		{Name: "pods/status", Kind: "Pod"},
+		{Name: "configmaps", Kind: "ConfigMap"},
+	}},
+	{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+		{Name: "deployments", Kind: "Deployment"},
+		{Name: "deployments/scale", Kind: "Scale"},
+		{Name: "replicasets", Kind: "ReplicaSet"},
+		{Name: "controllerrevisions", Kind: "ControllerRevision"},
+	}},
+	{GroupVersion: "batch/v1", APIResources: []metav1.APIResource{
+		{Name: "jobs", Kind: "Job"},
+		{Name: "cronjobs", Kind: "CronJob"},
+	}},
+}
+
+func TestResourceRules(t *testing.T) {
+	createUpdate := []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update}
+	create := []admissionregistrationv1.OperationType{admissionregistrationv1.Create}
+
+	tests := []struct {
+		name      string
+		selectors []ResourceSelector
+		mutate    bool
+		want      map[string][]admissionregistrationv1.OperationType
+	}{
+		{
+			name:      "pods are left to the pod webhooks",
+			selectors: []ResourceSelector{{Kind: "Pod"}},
+			mutate:    true,
+			want:      map[string][]admissionregistrationv1.OperationType{},
+		},
+		{
+			name:      "wildcard kind expands to workloads",
+			selectors: []ResourceSelector{{Group: "apps", Kind: "*"}},
+			mutate:    true,
+			want:      map[string][]admissionregistrationv1.OperationType{"apps/v1/deployments": createUpdate},
+		},
+		{
+			name:      "explicit kind",
+			selectors: []ResourceSelector{{Group: "apps", Kind: "ReplicaSet"}, {Kind: "ConfigMap"}},
+			mutate:    true,
+			want:      map[string][]admissionregistrationv1.OperationType{"apps/v1/replicasets": createUpdate, "v1/configmaps": createUpdate},
+		},
+		{
+			name:      "jobs are mutated on creation only",
+			selectors: []ResourceSelector{{Group: "batch", Kind: "*"}},
+			mutate:    true,
+			want:      map[string][]admissionregistrationv1.OperationType{"batch/v1/jobs": create, "batch/v1/cronjobs": createUpdate},
+		},
+		{
+			name:      "jobs are validated on update",
+			selectors: []ResourceSelector{{Group: "batch", Kind: "Job"}},
+			want:      map[string][]admissionregistrationv1.OperationType{"batch/v1/jobs": createUpdate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string][]admissionregistrationv1.OperationType{}
+			for _, rule := range resourceRules(testResources, &Config{}, tt.selectors, tt.mutate) {
+				for _, resource := range rule.Resources {
+					key := rule.APIVersions[0] + "/" + resource
+					if group := rule.APIGroups[0]; group != "" {
+						key = group + "/" + key
+					}
+					got[key] = rule.Operations
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resourceRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	webhook := webhookOptions{namespace: "default", serviceName: "pod-admission-controller", mutatingConfig: "pod-creation-webhook", validatingConfig: "pod-status-validator"}
+	meta := func(name, managedBy string) metav1.ObjectMeta {
+		meta := metav1.ObjectMeta{Name: name}
+		if managedBy != "" {
+			meta.Labels = map[string]string{managedByLabel: managedBy}
+		}
+		return meta
+	}
+
+	tests := []struct {
+		name          string
+		managedBy     string
+		missing       bool
+		wantRemaining bool
+	}{
+		{name: "registered by the webhook", managedBy: webhook.serviceName},
+		{name: "applied from manifests", wantRemaining: true},
+		{name: "registered by another webhook", managedBy: "other-webhook", wantRemaining: true},
+		{name: "missing", missing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if !tt.missing {
+				objects = append(objects,
+					&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: meta(webhook.mutatingConfig, tt.managedBy)},
+					&admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: meta(webhook.validatingConfig, tt.managedBy)},
+				)
+			}
+			client := fake.NewClientset(objects...)
+			registrar := &webhookRegistrar{client: client, webhook: webhook}
+
+			if err := registrar.unregister(context.Background()); err != nil {
+				t.Fatalf("unregister() error = %v", err)
+			}
+
+			admission := client.AdmissionregistrationV1()
+			_, err := admission.MutatingWebhookConfigurations().Get(context.Background(), webhook.mutatingConfig, metav1.GetOptions{})
+			if remaining := !apierrors.IsNotFound(err); remaining != tt.wantRemaining {
+				t.Errorf("MutatingWebhookConfiguration remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+			_, err = admission.ValidatingWebhookConfigurations().Get(context.Background(), webhook.validatingConfig, metav1.GetOptions{})
+			if remaining := !apierrors.IsNotFound(err); remaining != tt.wantRemaining {
+				t.Errorf("ValidatingWebhookConfiguration remaining = %v, want %v", remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
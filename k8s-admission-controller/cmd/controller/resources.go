@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// wildcard matches any group, version or kind in a resource selector
+const wildcard = "*"
+
+var podGroupKind = schema.GroupKind{Group: "", Kind: "Pod"}
+
+// ResourceSelector scopes a rule to a GroupVersionKind. An empty version and
+// "*" match any value; an empty group is the core API group.
+type ResourceSelector struct {
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version,omitempty"`
+	Kind    string `json:"kind"`
+}
+
+func (s ResourceSelector) matches(gvk schema.GroupVersionKind) bool {
+	return (s.Group == wildcard || s.Group == gvk.Group) &&
+		(s.Version == "" || s.Version == wildcard || s.Version == gvk.Version) &&
+		(s.Kind == wildcard || s.Kind == gvk.Kind)
+}
+
+// PodTemplate locates the pod template of a workload resource, whose
+// metadata is mutated instead of the metadata of the workload itself
+type PodTemplate struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+	// Path is the dot separated path of the template, e.g. spec.template
+	Path string `json:"path"`
+	// Immutable templates, such as the template of Jobs, are rejected by the
+	// API server when they change, so they are only mutated on creation
+	Immutable bool `json:"immutable,omitempty"`
+}
+
+// builtinPodTemplates are the pod templates of the in-tree workload resources
+var builtinPodTemplates = []PodTemplate{
+	{Group: "", Kind: "ReplicationController", Path: "spec.template"},
+	{Group: "", Kind: "PodTemplate", Path: "template"},
+	{Group: "apps", Kind: "Deployment", Path: "spec.template"},
+	{Group: "apps", Kind: "ReplicaSet", Path: "spec.template"},
+	{Group: "apps", Kind: "StatefulSet", Path: "spec.template"},
+	{Group: "apps", Kind: "DaemonSet", Path: "spec.template"},
+	{Group: "batch", Kind: "Job", Path: "spec.template", Immutable: true},
+	{Group: "batch", Kind: "CronJob", Path: "spec.jobTemplate.spec.template"},
+}
+
+// derivedWorkloads are created by other workloads from their own, already
+// mutated, pod template. "*" kind selectors do not expand to them when the
+// webhook registers itself.
+var derivedWorkloads = sets.New(schema.GroupKind{Group: "apps", Kind: "ReplicaSet"})
+
+// appliesTo reports whether the selectors match the kind of gvk. Only Pods
+// are matched when there are no selectors.
+func appliesTo(selectors []ResourceSelector, gvk schema.GroupVersionKind) bool {
+	if len(selectors) == 0 {
+		return gvk.GroupKind() == podGroupKind
+	}
+	for _, selector := range selectors {
+		if selector.matches(gvk) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateResourceSelectors(selectors []ResourceSelector, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, selector := range selectors {
+		if selector.Kind == "" {
+			errs = append(errs, field.Required(path.Index(i).Child("kind"), "kind is required"))
+		}
+	}
+	return errs
+}
+
+func validatePodTemplates(templates []PodTemplate, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, template := range templates {
+		if template.Kind == "" {
+			errs = append(errs, field.Required(path.Index(i).Child("kind"), "kind is required"))
+		}
+		if template.Path == "" {
+			errs = append(errs, field.Required(path.Index(i).Child("path"), "path is required"))
+		}
+	}
+	return errs
+}
+
+// metadataTarget is the metadata mutated for an admitted object
+type metadataTarget struct {
+	// fields are the path of the metadata within the object
+	fields      []string
+	labels      map[string]string
+	annotations map[string]string
+	// exists is false when the metadata has to be created
+	exists bool
+	// writable is false when the object lacks the pod template holding the
+	// metadata, leaving nothing to mutate
+	writable bool
+	// immutable is true for pod templates that cannot change after creation
+	immutable bool
+}
+
+// pointer returns the JSON pointer of the metadata, e.g. /spec/template/metadata
+func (t *metadataTarget) pointer() string {
+	return "/" + strings.Join(t.fields, "/")
+}
+
+// metadataTargetFor returns the pod template metadata of workload resources
+// and the object metadata of any other resource. Configured pod templates
+// take precedence over the built-in ones.
+func (c *Config) metadataTargetFor(obj *unstructured.Unstructured, gk schema.GroupKind) *metadataTarget {
+	fields := []string{"metadata"}
+	template := c.podTemplateFor(gk)
+	if template != nil {
+		fields = append(strings.Split(template.Path, "."), "metadata")
+	}
+
+	target := &metadataTarget{fields: fields, writable: true, immutable: template != nil && template.Immutable}
+	if len(fields) > 1 {
+		_, target.writable, _ = unstructured.NestedFieldNoCopy(obj.Object, fields[:len(fields)-1]...)
+	}
+	_, target.exists, _ = unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	target.labels, _, _ = unstructured.NestedStringMap(obj.Object, append(fields, "labels")...)
+	target.annotations, _, _ = unstructured.NestedStringMap(obj.Object, append(fields, "annotations")...)
+	return target
+}
+
+// podTemplateFor returns the pod template of a workload kind, or nil for
+// other kinds. Configured pod templates take precedence over the built-in
+// ones.
+func (c *Config) podTemplateFor(gk schema.GroupKind) *PodTemplate {
+	for _, templates := range [][]PodTemplate{c.PodTemplates, builtinPodTemplates} {
+		for i := range templates {
+			if templates[i].Group == gk.Group && templates[i].Kind == gk.Kind {
+				return &templates[i]
+			}
+		}
+	}
+	return nil
+}
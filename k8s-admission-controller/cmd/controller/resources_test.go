@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// testWorkload returns an unstructured object with a pod template at path
+func testWorkload(apiVersion, kind string, path ...string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName("web")
+	obj.SetNamespace("team-a")
+	if len(path) > 0 {
+		template := map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "web"}},
+		}
+		if err := unstructured.SetNestedMap(obj.Object, template, path...); err != nil {
+			panic(err)
+		}
+	}
+	return obj
+}
+
+func TestMetadataTargetFor(t *testing.T) {
+	config := &Config{PodTemplates: []PodTemplate{
+		{Group: "example.com", Kind: "Worker", Path: "spec.worker.template"},
+		{Group: "apps", Kind: "Deployment", Path: "spec.custom"},
+	}}
+
+	tests := []struct {
+		name          string
+		obj           *unstructured.Unstructured
+		wantPointer   string
+		wantWritable  bool
+		wantImmutable bool
+		wantLabels    map[string]string
+	}{
+		{
+			name:         "pod",
+			obj:          testPod(),
+			wantPointer:  "/metadata",
+			wantWritable: true,
+			wantLabels:   map[string]string{"app": "web"},
+		},
+		{
+			name:         "built-in workload",
+			obj:          testWorkload("apps/v1", "StatefulSet", "spec", "template"),
+			wantPointer:  "/spec/template/metadata",
+			wantWritable: true,
+			wantLabels:   map[string]string{"app": "web"},
+		},
+		{
+			name:         "nested built-in template",
+			obj:          testWorkload("batch/v1", "CronJob", "spec", "jobTemplate", "spec", "template"),
+			wantPointer:  "/spec/jobTemplate/spec/template/metadata",
+			wantWritable: true,
+			wantLabels:   map[string]string{"app": "web"},
+		},
+		{
+			name:          "immutable template",
+			obj:           testWorkload("batch/v1", "Job", "spec", "template"),
+			wantPointer:   "/spec/template/metadata",
+			wantWritable:  true,
+			wantImmutable: true,
+			wantLabels:    map[string]string{"app": "web"},
+		},
+		{
+			name:         "configured template",
+			obj:          testWorkload("example.com/v1", "Worker", "spec", "worker", "template"),
+			wantPointer:  "/spec/worker/template/metadata",
+			wantWritable: true,
+			wantLabels:   map[string]string{"app": "web"},
+		},
+		{
+			name:         "configured template takes precedence",
+			obj:          testWorkload("apps/v1", "Deployment", "spec", "custom"),
+			wantPointer:  "/spec/custom/metadata",
+			wantWritable: true,
+			wantLabels:   map[string]string{"app": "web"},
+		},
+		{
+			name:        "missing template",
+			obj:         testWorkload("apps/v1", "StatefulSet"),
+			wantPointer: "/spec/template/metadata",
+		},
+		{
+			name:         "other resource",
+			obj:          testWorkload("v1", "ConfigMap"),
+			wantPointer:  "/metadata",
+			wantWritable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := config.metadataTargetFor(tt.obj, tt.obj.GroupVersionKind().GroupKind())
+			if got := target.pointer(); got != tt.wantPointer {
+				t.Errorf("pointer() = %q, want %q", got, tt.wantPointer)
+			}
+			if target.writable != tt.wantWritable || target.immutable != tt.wantImmutable {
+				t.Errorf("writable, immutable = %v, %v, want %v, %v", target.writable, target.immutable, tt.wantWritable, tt.wantImmutable)
+			}
+			if len(target.labels) != len(tt.wantLabels) || target.labels["app"] != tt.wantLabels["app"] {
+				t.Errorf("labels = %v, want %v", target.labels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestMutationsForImmutableTemplate(t *testing.T) {
+	config, err := parseConfig([]byte("rules:\n  - name: team\n    value: web\n    resources:\n      - group: batch\n        kind: Job\n"))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	for _, operation := range []admissionv1.Operation{admissionv1.Create, admissionv1.Update} {
+		t.Run(string(operation), func(t *testing.T) {
+			request := &admissionv1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}),
+				Operation: operation,
+			}
+			changes, _ := config.mutationsFor(newRuleContext(context.Background(), testWorkload("batch/v1", "Job", "spec", "template"), request))
+			want := 1
+			if operation == admissionv1.Update {
+				want = 0
+			}
+			if got := len(changes.Labels.Set); got != want {
+				t.Errorf("mutationsFor() set %v, want %d labels", changes.Labels.Set, want)
+			}
+		})
+	}
+}
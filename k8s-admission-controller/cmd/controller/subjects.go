@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// serviceAccountUsernamePrefix prefixes the user names of service accounts
+const serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+// SubjectSelector matches the user making an admission request. Patterns
+// ending in "*" match any value with the same prefix.
+type SubjectSelector struct {
+	// Users are user names, e.g. jane@example.com or oidc:ops-*
+	Users []string `json:"users,omitempty"`
+	// Groups are group names, e.g. break-glass
+	Groups []string `json:"groups,omitempty"`
+	// ServiceAccounts are namespace/name pairs, e.g. ops/break-glass
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+func (s *SubjectSelector) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, user := range s.Users {
+		if !isSuffixPattern(user) {
+			errs = append(errs, field.Invalid(path.Child("users").Index(i), user, "* is only allowed at the end"))
+		}
+	}
+	for i, group := range s.Groups {
+		if !isSuffixPattern(group) {
+			errs = append(errs, field.Invalid(path.Child("groups").Index(i), group, "* is only allowed at the end"))
+		}
+	}
+	for i, account := range s.ServiceAccounts {
+		namespace, name, ok := strings.Cut(account, "/")
+		switch {
+		case !ok || namespace == "" || name == "":
+			errs = append(errs, field.Invalid(path.Child("serviceAccounts").Index(i), account, "must be namespace/name"))
+		case strings.Contains(namespace, wildcard) || !isSuffixPattern(name):
+			errs = append(errs, field.Invalid(path.Child("serviceAccounts").Index(i), account, "* is only allowed at the end of the name"))
+		}
+	}
+	return errs
+}
+
+// isSuffixPattern reports whether pattern has no "*" but a trailing one,
+// the only wildcard matchesPattern supports
+func isSuffixPattern(pattern string) bool {
+	return !strings.Contains(strings.TrimSuffix(pattern, wildcard), wildcard)
+}
+
+// matches reports whether user matches any of the users, groups or service
+// accounts of the selector
+func (s *SubjectSelector) matches(user authenticationv1.UserInfo) bool {
+	for _, pattern := range s.Users {
+		if matchesPattern(pattern, user.Username) {
+			return true
+		}
+	}
+	for _, pattern := range s.Groups {
+		for _, group := range user.Groups {
+			if matchesPattern(pattern, group) {
+				return true
+			}
+		}
+	}
+	for _, account := range s.ServiceAccounts {
+		namespace, name, _ := strings.Cut(account, "/")
+		if matchesPattern(serviceAccountUsernamePrefix+namespace+":"+name, user.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(pattern, value string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, wildcard); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return pattern == value
+}
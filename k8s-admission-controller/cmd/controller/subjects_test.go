@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestSubjectSelectorValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector SubjectSelector
+		wantErr  string
+	}{
+		{name: "exact values", selector: SubjectSelector{Users: []string{"jane"}, Groups: []string{"break-glass"}, ServiceAccounts: []string{"ci/deployer"}}},
+		{name: "trailing wildcards", selector: SubjectSelector{Users: []string{"system:serviceaccount:kube-system:*"}, Groups: []string{"*"}, ServiceAccounts: []string{"kube-system/*", "ci/deploy-*"}}},
+		{name: "leading user wildcard", selector: SubjectSelector{Users: []string{"*:admin"}}, wantErr: "users[0]: Invalid value"},
+		{name: "inner group wildcard", selector: SubjectSelector{Groups: []string{"team-*-admins"}}, wantErr: "groups[0]: Invalid value"},
+		{name: "namespace wildcard", selector: SubjectSelector{ServiceAccounts: []string{"*/deployer"}}, wantErr: "serviceAccounts[0]: Invalid value"},
+		{name: "namespace prefix wildcard", selector: SubjectSelector{ServiceAccounts: []string{"team-*/deployer"}}, wantErr: "serviceAccounts[0]: Invalid value"},
+		{name: "inner name wildcard", selector: SubjectSelector{ServiceAccounts: []string{"ci/*-bot"}}, wantErr: "serviceAccounts[0]: Invalid value"},
+		{name: "missing name", selector: SubjectSelector{ServiceAccounts: []string{"ci"}}, wantErr: "must be namespace/name"},
+		{name: "empty namespace", selector: SubjectSelector{ServiceAccounts: []string{"/deployer"}}, wantErr: "must be namespace/name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.selector.validate(field.NewPath("subjects")).ToAggregate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubjectSelectorMatches(t *testing.T) {
+	selector := SubjectSelector{
+		Users:           []string{"jane", "system:node:*"},
+		Groups:          []string{"break-glass"},
+		ServiceAccounts: []string{"kube-system/*", "ci/deployer"},
+	}
+
+	tests := []struct {
+		name string
+		user authenticationv1.UserInfo
+		want bool
+	}{
+		{name: "exact user", user: authenticationv1.UserInfo{Username: "jane"}, want: true},
+		{name: "user prefix", user: authenticationv1.UserInfo{Username: "system:node:worker-1"}, want: true},
+		{name: "other user", user: authenticationv1.UserInfo{Username: "janet"}},
+		{name: "group", user: authenticationv1.UserInfo{Username: "bob", Groups: []string{"dev", "break-glass"}}, want: true},
+		{name: "other group", user: authenticationv1.UserInfo{Username: "bob", Groups: []string{"dev"}}},
+		{name: "service account of any name", user: authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller"}, want: true},
+		{name: "exact service account", user: authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer"}, want: true},
+		{name: "service account of another namespace", user: authenticationv1.UserInfo{Username: "system:serviceaccount:team-a:deployer"}},
+		{name: "anonymous", user: authenticationv1.UserInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selector.matches(tt.user); got != tt.want {
+				t.Errorf("matches(%+v) = %v, want %v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
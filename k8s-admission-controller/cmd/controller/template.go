@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// noValue is what text/template prints for a missing map key
+const noValue = "<no value>"
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// templateFuncs are the helpers available to label value templates
+var templateFuncs = template.FuncMap{
+	"default":         defaultValue,
+	"first":           first,
+	"imageRepository": imageRepository,
+	"imageName":       imageName,
+	"labelValue":      labelValue,
+	"lower":           strings.ToLower,
+	"replace":         strings.ReplaceAll,
+	"trimPrefix":      strings.TrimPrefix,
+	"trimSuffix":      strings.TrimSuffix,
+}
+
+// ruleContext carries the per-request inputs of rule evaluation
+type ruleContext struct {
+	object *unstructured.Unstructured
+	gvk    schema.GroupVersionKind
+	// data holds the evaluation variables, built lazily from the object and request
+	data    map[string]interface{}
+	request *admissionv1.AdmissionRequest
+	// topOwner memoizes the resolved top-level owner
+	topOwner *ownerInfo
+	resolved bool
+	// lookupCtx bounds the lookups made while evaluating the rules
+	lookupCtx context.Context
+}
+
+// namespaceName returns the namespace of the request, which is reliable even
+// when the object omits it, or of the object otherwise
+func (c *ruleContext) namespaceName() string {
+	if c.request != nil && c.request.Namespace != "" {
+		return c.request.Namespace
+	}
+	return c.object.GetNamespace()
+}
+
+// namespace returns the Namespace of the object from the informer cache
+func (c *ruleContext) namespace() *corev1.Namespace {
+	return lookupNamespace(c.namespaceName())
+}
+
+// newRuleContext creates the context of an admitted object. The kind is
+// taken from the request when there is one, and from the object otherwise.
+func newRuleContext(ctx context.Context, object *unstructured.Unstructured, request *admissionv1.AdmissionRequest) *ruleContext {
+	gvk := object.GroupVersionKind()
+	if request != nil {
+		gvk = schema.GroupVersionKind(request.Kind)
+	}
+	return &ruleContext{lookupCtx: ctx, object: object, gvk: gvk, request: request}
+}
+
+// isPod reports whether the admitted object is a Pod
+func (c *ruleContext) isPod() bool {
+	return c.gvk.GroupKind() == podGroupKind
+}
+
+// owner returns the top-level owner of the object, resolved once per request.
+// The whole walk up the ownership chain shares one deadline.
+func (c *ruleContext) owner() *ownerInfo {
+	if !c.resolved {
+		ctx, cancel := context.WithTimeout(c.lookupCtx, ownerLookupTimeout)
+		defer cancel()
+		c.topOwner = owners.topLevelOwner(ctx, c.namespaceName(), c.object.GetOwnerReferences())
+		c.resolved = true
+	}
+	return c.topOwner
+}
+
+// variables exposes the object as object, the previous object as oldObject
+// (nil on CREATE) and the admission request as request, using their JSON field
+// names, e.g. object.spec.serviceAccountName or request.userInfo.username.
+// They are shared by templates and CEL expressions.
+func (c *ruleContext) variables() (map[string]interface{}, error) {
+	if c.data != nil {
+		return c.data, nil
+	}
+
+	var err error
+	var oldObject interface{}
+	request := map[string]interface{}{}
+	if c.request != nil {
+		if len(c.request.OldObject.Raw) > 0 {
+			old := map[string]interface{}{}
+			if err := json.Unmarshal(c.request.OldObject.Raw, &old); err != nil {
+				return nil, fmt.Errorf("failed to decode old object: %v", err)
+			}
+			oldObject = old
+		}
+
+		// The objects are already exposed as object and oldObject
+		trimmed := *c.request
+		trimmed.Object.Raw = nil
+		trimmed.OldObject.Raw = nil
+		if request, err = toMap(&trimmed); err != nil {
+			return nil, fmt.Errorf("failed to convert admission request: %v", err)
+		}
+	}
+
+	c.data = map[string]interface{}{
+		"object":    c.object.Object,
+		"oldObject": oldObject,
+		"request":   request,
+	}
+	return c.data, nil
+}
+
+// parseTemplate compiles a label value template
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+}
+
+// executeTemplate renders tmpl for ctx. Missing fields render as an empty
+// string.
+func executeTemplate(tmpl *template.Template, ctx *ruleContext) (string, error) {
+	data, err := ctx.variables()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(strings.ReplaceAll(buf.String(), noValue, "")), nil
+}
+
+// toMap converts a Kubernetes object into its generic JSON representation
+func toMap(obj interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func defaultValue(def interface{}, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	if s, ok := value.(string); ok && s == "" {
+		return def
+	}
+	return value
+}
+
+func first(list interface{}) interface{} {
+	if items, ok := list.([]interface{}); ok && len(items) > 0 {
+		return items[0]
+	}
+	return nil
+}
+
+// imageRepository strips the registry, tag and digest from an image
+// reference, e.g. docker.io/library/nginx:1.27 becomes library/nginx.
+func imageRepository(image interface{}) string {
+	ref, _ := image.(string)
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		host := ref[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref = ref[i+1:]
+		}
+	}
+	return ref
+}
+
+// imageName returns the last path segment of an image repository
+func imageName(image interface{}) string {
+	repository := imageRepository(image)
+	return repository[strings.LastIndex(repository, "/")+1:]
+}
+
+// labelValue coerces a string into a valid label value by replacing invalid
+// characters with dashes and truncating it to the maximum length.
+func labelValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	s := invalidLabelValueChars.ReplaceAllString(fmt.Sprint(value), "-")
+	if len(s) > validation.LabelValueMaxLength {
+		s = s[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(s, "-_.")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// testPod returns an unstructured Pod named web-0 in team-a
+func testPod() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":      "web-0",
+			"namespace": "team-a",
+			"labels":    map[string]interface{}{"app": "web"},
+		},
+		"spec": map[string]interface{}{
+			"serviceAccountName": "web",
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "registry.example.com:5000/team/web:1.2@sha256:0123"},
+				map[string]interface{}{"name": "proxy", "image": "envoyproxy/envoy:v1.31"},
+			},
+		},
+	}}
+}
+
+// testRequest returns a Pod CREATE request made by username
+func testRequest(username string) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: admissionv1.Create,
+		Namespace: "team-a",
+		UserInfo:  authenticationv1.UserInfo{Username: username},
+	}
+}
+
+func TestImageRepository(t *testing.T) {
+	tests := []struct {
+		image          interface{}
+		wantRepository string
+		wantName       string
+	}{
+		{image: "nginx", wantRepository: "nginx", wantName: "nginx"},
+		{image: "nginx:1.27", wantRepository: "nginx", wantName: "nginx"},
+		{image: "library/nginx:1.27", wantRepository: "library/nginx", wantName: "nginx"},
+		{image: "docker.io/library/nginx:1.27", wantRepository: "library/nginx", wantName: "nginx"},
+		{image: "localhost/app", wantRepository: "app", wantName: "app"},
+		{image: "localhost:5000/app:v1", wantRepository: "app", wantName: "app"},
+		{image: "registry.example.com:5000/team/app", wantRepository: "team/app", wantName: "app"},
+		{image: "ghcr.io/org/app:v1@sha256:0123", wantRepository: "org/app", wantName: "app"},
+		{image: "ghcr.io/org/app@sha256:0123", wantRepository: "org/app", wantName: "app"},
+		{image: "", wantRepository: "", wantName: ""},
+		{image: nil, wantRepository: "", wantName: ""},
+	}
+
+	for _, tt := range tests {
+		if got := imageRepository(tt.image); got != tt.wantRepository {
+			t.Errorf("imageRepository(%v) = %q, want %q", tt.image, got, tt.wantRepository)
+		}
+		if got := imageName(tt.image); got != tt.wantName {
+			t.Errorf("imageName(%v) = %q, want %q", tt.image, got, tt.wantName)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: "web", want: "web"},
+		{value: "system:serviceaccount:team-a:deployer", want: "system-serviceaccount-team-a-deployer"},
+		{value: "jane@example.com", want: "jane-example.com"},
+		{value: "-_.trimmed._-", want: "trimmed"},
+		{value: strings.Repeat("a", 70), want: strings.Repeat("a", 63)},
+		{value: 42, want: "42"},
+		{value: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := labelValue(tt.value); got != tt.want {
+			t.Errorf("labelValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "object field", template: "{{ .object.metadata.name }}", want: "web-0"},
+		{name: "request field", template: "{{ .request.userInfo.username | labelValue }}", want: "system-serviceaccount-team-a-deployer"},
+		{name: "missing field renders empty", template: "{{ .object.metadata.labels.team }}", want: ""},
+		{name: "missing old object renders empty", template: "{{ .oldObject }}", want: ""},
+		{name: "default", template: `{{ default "none" .object.metadata.labels.team }}`, want: "none"},
+		{name: "first container image", template: "{{ (first .object.spec.containers).image | imageRepository }}", want: "team/web"},
+		{name: "pipeline", template: `{{ .object.spec.serviceAccountName | printf "%s-sa" | lower }}`, want: "web-sa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseTemplate(tt.name, tt.template)
+			if err != nil {
+				t.Fatalf("parseTemplate() error = %v", err)
+			}
+			got, err := executeTemplate(tmpl, newRuleContext(context.Background(), testPod(), testRequest("system:serviceaccount:team-a:deployer")))
+			if err != nil {
+				t.Fatalf("executeTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("executeTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     string
+		target       string
+		want         string
+		wantResolved bool
+	}{
+		{name: "valid label value", template: "{{ .object.metadata.labels.app }}", target: targetLabel, want: "web", wantResolved: true},
+		{name: "empty value uses default", template: "{{ .object.metadata.labels.team }}", target: targetLabel, want: "unknown"},
+		{name: "invalid label value uses default", template: "{{ .request.userInfo.username }}", target: targetLabel, want: "unknown"},
+		{name: "annotations accept any value", template: "{{ .request.userInfo.username }}", target: targetAnnotation, want: "jane@example.com", wantResolved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: "team", Target: tt.target, Template: tt.template, Default: "unknown"}
+			var err error
+			if rule.tmpl, err = parseTemplate(rule.Name, rule.Template); err != nil {
+				t.Fatalf("parseTemplate() error = %v", err)
+			}
+			got, resolved := rule.resolve(newRuleContext(context.Background(), testPod(), testRequest("jane@example.com")))
+			if got != tt.want || resolved != tt.wantResolved {
+				t.Errorf("resolve() = %q, %v, want %q, %v", got, resolved, tt.want, tt.wantResolved)
+			}
+		})
+	}
+}
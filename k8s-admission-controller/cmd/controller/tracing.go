@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+const serviceName = "pod-admission-controller"
+
+// tracer creates the spans of the webhook. Spans are dropped unless tracing
+// is set up with an OTLP endpoint.
+var tracer = otel.Tracer("github.com/guirgouveia/k8s-admission-controller")
+
+// setupTracing exports spans over OTLP/HTTP to endpoint, e.g.
+// http://otel-collector:4318. The returned function flushes the pending
+// spans. Tracing stays disabled when endpoint is empty.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(buildTime),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// traceClient traces the requests of the clients built from config, so that
+// API calls such as the PATCH of a pod appear as child spans
+func traceClient(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt)
+	})
+}
+
+// traceHandler starts a span for every request served by handler, continuing
+// the trace of the caller when the request carries one
+func traceHandler(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "admission "+endpoint, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// reviewAttributes identify the admission request and its object on spans.
+// Pods are also identified by their pod name.
+func reviewAttributes(request *admissionv1.AdmissionRequest, name string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		attribute.String("admission.uid", string(request.UID)),
+		attribute.String("admission.operation", string(request.Operation)),
+		attribute.String("admission.kind", request.Kind.Kind),
+		attribute.Bool("admission.dry_run", isDryRun(request)),
+		semconv.K8SNamespaceName(request.Namespace),
+		attribute.String("admission.name", name),
+	}
+	if schema.GroupVersionKind(request.Kind).GroupKind() == podGroupKind {
+		attributes = append(attributes, semconv.K8SPodName(name))
+	}
+	return attributes
+}
+
+// podAttributes identify a pod on the spans of the pod labeler
+func podAttributes(pod *corev1.Pod) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.K8SNamespaceName(pod.Namespace),
+		semconv.K8SPodName(pod.Name),
+		semconv.K8SPodUID(string(pod.UID)),
+	}
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	spansOnce sync.Once
+	spans     *tracetest.InMemoryExporter
+)
+
+// recordSpans records the spans ended during the test in memory. The tracer
+// provider is only installed once, as tracer delegates to the first one set.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	spansOnce.Do(func() {
+		spans = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	})
+	spans.Reset()
+	t.Cleanup(spans.Reset)
+	return spans
+}
+
+// setTestOwners reads owners from an API server serving a single
+// ReplicaSet, through a client traced like the clients of the webhook
+func setTestOwners(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/apps/v1/namespaces/team-a/replicasets/web-5d8f" {
+			http.NotFound(w, r)
+			return
+		}
+		owner := testOwner("apps/v1", "ReplicaSet", "web-5d8f", nil)
+		owner.TypeMeta = metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(owner)
+	}))
+	t.Cleanup(server.Close)
+
+	config := &rest.Config{Host: server.URL}
+	traceClient(config)
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	close(stopCh)
+
+	previous := owners
+	owners = newTestOwnerResolver(client, stopCh)
+	t.Cleanup(func() { owners = previous })
+}
+
+func TestAdmissionSpans(t *testing.T) {
+	exporter := recordSpans(t)
+	setTestNamespaces(t)
+	setTestOwners(t)
+	setTestRules(t, "rules:\n  - name: owningResource\n    source: owningResource\n    default: None\n")
+
+	pod := testPod()
+	pod.SetOwnerReferences([]metav1.OwnerReference{*controllerRef("apps/v1", "ReplicaSet", "web-5d8f")})
+	raw, err := json.Marshal(pod.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := testRequest("jane")
+	request.UID = "7b3e2f0c"
+	request.Object = runtime.RawExtension{Raw: raw}
+	review(t, traceHandler(mutatePath, handleMutation), mutatePath, request)
+
+	byName := map[string]tracetest.SpanStub{}
+	var clientSpans []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		byName[span.Name] = span
+		if span.SpanKind == trace.SpanKindClient {
+			clientSpans = append(clientSpans, span)
+		}
+	}
+
+	root, ok := byName["admission "+mutatePath]
+	if !ok {
+		t.Fatalf("no admission span in %v", exporter.GetSpans())
+	}
+	for key, want := range map[attribute.Key]string{
+		"admission.uid":      "7b3e2f0c",
+		"k8s.namespace.name": "team-a",
+		"k8s.pod.name":       "web-0",
+	} {
+		if got := attributeValue(root.Attributes, key); got != want {
+			t.Errorf("admission span attribute %s = %q, want %q", key, got, want)
+		}
+	}
+
+	for _, name := range []string{"decode", "evaluate rules", "generate patch", "write response"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("%q span is not a child of the admission span", name)
+		}
+	}
+
+	// The owner lookup of the rules is traced below their evaluation
+	if len(clientSpans) != 1 {
+		t.Fatalf("got %d API request spans, want 1", len(clientSpans))
+	}
+	if clientSpans[0].Parent.SpanID() != byName["evaluate rules"].SpanContext.SpanID() {
+		t.Error("owner lookup span is not a child of the evaluate rules span")
+	}
+}
+
+func TestLabelPodSpan(t *testing.T) {
+	exporter := recordSpans(t)
+	setTestRules(t, "nodeLabels: []\nrules:\n  - name: nodeName\n    source: nodeName\n    default: pending\n")
+
+	labeler := newTestPodLabeler(t, scheduledPod())
+	if err := labeler.sync("team-a/web-0"); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	got := exporter.GetSpans()
+	if len(got) != 1 || got[0].Name != "label pod" {
+		t.Fatalf("got spans %v, want a label pod span", got)
+	}
+	for key, want := range map[attribute.Key]string{
+		"k8s.namespace.name": "team-a",
+		"k8s.pod.name":       "web-0",
+		"k8s.pod.uid":        "0f6f8f3e",
+	} {
+		if got := attributeValue(got[0].Attributes, key); got != want {
+			t.Errorf("label pod span attribute %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func attributeValue(attributes []attribute.KeyValue, key attribute.Key) string {
+	for _, kv := range attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// LabelRequirement is a label objects must carry to be admitted by the
+// validating endpoint
+type LabelRequirement struct {
+	// Name is the label key
+	Name string `json:"name"`
+	// Resources scopes the requirement to resource kinds. Only Pods are
+	// validated when empty. Workloads are validated on their pod template.
+	Resources []ResourceSelector `json:"resources,omitempty"`
+	// Values is the allow-list of label values
+	Values []string `json:"values,omitempty"`
+	// Pattern is a regular expression the whole label value must match
+	Pattern string `json:"pattern,omitempty"`
+	// Message replaces the default message of a violation
+	Message string `json:"message,omitempty"`
+	// Mode overrides the default enforcement mode of violations
+	Mode string `json:"mode,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Enforcement modes of required labels, named after the validation actions
+// of ValidatingAdmissionPolicyBindings
+const (
+	// modeDeny rejects the object
+	modeDeny = "Deny"
+	// modeWarn admits the object with a warning shown by kubectl
+	modeWarn = "Warn"
+	// modeAudit admits the object and records the violation in the audit log
+	modeAudit = "Audit"
+)
+
+var supportedModes = sets.New(modeDeny, modeWarn, modeAudit)
+
+// defaultEnforcementLabel is the Namespace label selecting the enforcement
+// mode of all requirements for the objects in the Namespace
+const defaultEnforcementLabel = "admission.jumads.com/enforcement"
+
+// EnforcementConfig selects how violations of required labels are handled
+type EnforcementConfig struct {
+	// Mode is the mode of requirements without their own, Deny when empty
+	Mode string `json:"mode,omitempty"`
+	// NamespaceLabel is the Namespace label that overrides the mode of all
+	// requirements. It defaults to admission.jumads.com/enforcement.
+	NamespaceLabel string `json:"namespaceLabel,omitempty"`
+}
+
+func (c *EnforcementConfig) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if c.Mode != "" && !supportedModes.Has(c.Mode) {
+		errs = append(errs, field.NotSupported(path.Child("mode"), c.Mode, sets.List(supportedModes)))
+	}
+	if c.NamespaceLabel != "" {
+		for _, msg := range validation.IsQualifiedName(c.NamespaceLabel) {
+			errs = append(errs, field.Invalid(path.Child("namespaceLabel"), c.NamespaceLabel, msg))
+		}
+	}
+	return errs
+}
+
+// modeFor returns the enforcement mode of requirement for objects in
+// namespace. A valid mode set by the Namespace label takes precedence over
+// the mode of the requirement, which takes precedence over the default.
+func (c *EnforcementConfig) modeFor(requirement *LabelRequirement, namespace *corev1.Namespace) string {
+	if namespace != nil {
+		label := c.NamespaceLabel
+		if label == "" {
+			label = defaultEnforcementLabel
+		}
+		if mode, ok := namespace.Labels[label]; ok {
+			if supportedModes.Has(mode) {
+				return mode
+			}
+			log.WithFields(log.Fields{
+				"namespace": namespace.Name,
+				"mode":      mode,
+			}).Debug("Ignoring unsupported enforcement mode of Namespace")
+		}
+	}
+
+	switch {
+	case requirement.Mode != "":
+		return requirement.Mode
+	case c.Mode != "":
+		return c.Mode
+	default:
+		return modeDeny
+	}
+}
+
+// defaultRequiredLabels mirror the checks of policies/admission-policy.yaml,
+// except that any environment is accepted so that Namespaces can set it
+func defaultRequiredLabels() []LabelRequirement {
+	return []LabelRequirement{
+		{Name: "environment"},
+		{Name: "owningResource"},
+		{Name: "ipAddress"},
+		{Name: "nodeName"},
+	}
+}
+
+func validateLabelRequirements(requirements []LabelRequirement, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, requirement := range requirements {
+		path := path.Index(i)
+
+		errs = append(errs, validateResourceSelectors(requirement.Resources, path.Child("resources"))...)
+		if requirement.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "name is required"))
+		} else {
+			for _, msg := range validation.IsQualifiedName(requirement.Name) {
+				errs = append(errs, field.Invalid(path.Child("name"), requirement.Name, msg))
+			}
+		}
+		if seen[requirement.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), requirement.Name))
+		}
+		seen[requirement.Name] = true
+
+		if requirement.Mode != "" && !supportedModes.Has(requirement.Mode) {
+			errs = append(errs, field.NotSupported(path.Child("mode"), requirement.Mode, sets.List(supportedModes)))
+		}
+		for j, value := range requirement.Values {
+			for _, msg := range validation.IsValidLabelValue(value) {
+				errs = append(errs, field.Invalid(path.Child("values").Index(j), value, msg))
+			}
+		}
+	}
+	return errs
+}
+
+// compileLabelRequirements compiles the value patterns of the requirements
+func compileLabelRequirements(requirements []LabelRequirement, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i := range requirements {
+		requirement := &requirements[i]
+		if requirement.Pattern == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile("^(?:" + requirement.Pattern + ")$")
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Index(i).Child("pattern"), requirement.Pattern, err.Error()))
+		}
+		requirement.pattern = pattern
+	}
+	return errs
+}
+
+// check returns the violation of the requirement by the labels at path, or
+// nil when it is satisfied
+func (r *LabelRequirement) check(labels map[string]string, path *field.Path) *field.Error {
+	value, ok := labels[r.Name]
+	path = path.Key(r.Name)
+
+	switch {
+	case !ok:
+		return field.Required(path, r.message(fmt.Sprintf("label %s is required", r.Name)))
+	case len(r.Values) > 0 && !slices.Contains(r.Values, value):
+		if r.Message != "" {
+			return field.Invalid(path, value, r.Message)
+		}
+		return field.NotSupported(path, value, r.Values)
+	case r.pattern != nil && !r.pattern.MatchString(value):
+		return field.Invalid(path, value, r.message(fmt.Sprintf("must match %s", r.Pattern)))
+	}
+	return nil
+}
+
+func (r *LabelRequirement) message(fallback string) string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return fallback
+}
+
+// violationsFor returns the required labels the object in ctx is missing or
+// sets to a value that is not allowed, keyed by their enforcement mode.
+// Labels opted out of by Namespace annotations, or requested by exempt
+// subjects, are not required and are returned as exempted. The annotations
+// of the object itself are ignored.
+func (c *Config) violationsFor(ctx *ruleContext) (violations map[string]field.ErrorList, exempted []string) {
+	target := c.metadataTargetFor(ctx.object, ctx.gvk.GroupKind())
+	if !target.writable {
+		return nil, nil
+	}
+	path := field.NewPath(target.fields[0], target.fields[1:]...).Child("labels")
+
+	exemptions := c.namespaceExemptionsFor(ctx)
+	violations = map[string]field.ErrorList{}
+	for i := range c.RequiredLabels {
+		requirement := &c.RequiredLabels[i]
+		if !appliesTo(requirement.Resources, ctx.gvk) {
+			continue
+		}
+		if exemptions.exempts(requirement.Name) {
+			exempted = append(exempted, requirement.Name)
+			continue
+		}
+		if err := requirement.check(target.labels, path); err != nil {
+			mode := c.Enforcement.modeFor(requirement, ctx.namespace())
+			violations[mode] = append(violations[mode], err)
+		}
+	}
+	return violations, exempted
+}
+
+// enforce applies the violations to the admission response. Deny violations
+// reject the object with an Invalid status listing one cause per label, Warn
+// violations are returned as warnings and Audit violations as an audit
+// annotation.
+func enforce(response *admissionv1.AdmissionResponse, ctx *ruleContext, violations map[string]field.ErrorList) {
+	if errs := violations[modeDeny]; len(errs) > 0 {
+		status := apierrors.NewInvalid(ctx.gvk.GroupKind(), ctx.object.GetName(), errs).Status()
+		response.Allowed = false
+		response.Result = &status
+	}
+	for _, err := range violations[modeWarn] {
+		response.Warnings = append(response.Warnings, err.Error())
+	}
+	if errs := violations[modeAudit]; len(errs) > 0 {
+		addAuditAnnotation(response, "violations", errs.ToAggregate().Error())
+	}
+}
+
+// addAuditAnnotation records a value in the audit event of the request. The
+// API server prefixes the key with the name of the webhook.
+func addAuditAnnotation(response *admissionv1.AdmissionResponse, key, value string) {
+	if response.AuditAnnotations == nil {
+		response.AuditAnnotations = map[string]string{}
+	}
+	response.AuditAnnotations[key] = value
+}
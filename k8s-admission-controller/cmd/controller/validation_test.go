@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestViolationsForExemptions(t *testing.T) {
+	tests := []struct {
+		name                 string
+		namespaceAnnotations map[string]string
+		podAnnotations       map[string]string
+		username             string
+		wantDenied           int
+		wantExempted         int
+	}{
+		{name: "no exemptions", wantDenied: 2},
+		{name: "object disable annotation is ignored", podAnnotations: map[string]string{disableAnnotation: "true"}, wantDenied: 2},
+		{name: "object disable-keys annotation is ignored", podAnnotations: map[string]string{disableKeysAnnotation: "team"}, wantDenied: 2},
+		{name: "namespace disable annotation", namespaceAnnotations: map[string]string{disableAnnotation: "true"}, wantExempted: 2},
+		{name: "namespace disable-keys annotation", namespaceAnnotations: map[string]string{disableKeysAnnotation: "team"}, wantDenied: 1, wantExempted: 1},
+		{name: "exempt subject", username: "system:serviceaccount:ops:break-glass", wantExempted: 2},
+		{name: "controller is not exempt", username: "system:serviceaccount:kube-system:replicaset-controller", wantDenied: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestNamespaces(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: tt.namespaceAnnotations}})
+			config, err := parseConfig([]byte(`
+exemptSubjects:
+  serviceAccounts: [ops/break-glass]
+requiredLabels:
+  - name: team
+  - name: environment
+`))
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+			pod := testPod()
+			pod.SetAnnotations(tt.podAnnotations)
+
+			violations, exempted := config.violationsFor(newRuleContext(context.Background(), pod, testRequest(tt.username)))
+			if got := len(violations[modeDeny]); got != tt.wantDenied {
+				t.Errorf("violationsFor() denied %d labels, want %d", got, tt.wantDenied)
+			}
+			if got := len(exempted); got != tt.wantExempted {
+				t.Errorf("violationsFor() exempted %v, want %d labels", exempted, tt.wantExempted)
+			}
+		})
+	}
+}
+
+func TestExemptionsFor(t *testing.T) {
+	setTestNamespaces(t)
+	pod := testPod()
+	pod.SetAnnotations(map[string]string{disableKeysAnnotation: "team"})
+
+	// Mutation still honors the opt-outs of the object itself
+	if exemptions := (&Config{}).exemptionsFor(newRuleContext(context.Background(), pod, testRequest("jane"))); !exemptions.exempts("team") {
+		t.Error("exemptionsFor() does not exempt the keys of the object annotations")
+	}
+}
+
+// TestObjectOptOutsKeepRequiredLabels checks that the opt-outs of an object
+// do not keep mutation from setting the labels validation requires
+func TestObjectOptOutsKeepRequiredLabels(t *testing.T) {
+	config, err := parseConfig([]byte(`
+rules:
+  - name: environment
+    value: production
+  - name: team
+    value: web
+requiredLabels:
+  - name: environment
+`))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+
+	for _, annotations := range []map[string]string{
+		{disableAnnotation: "true"},
+		{disableKeysAnnotation: "environment,team"},
+	} {
+		setTestNamespaces(t)
+		pod := testPod()
+		pod.SetAnnotations(annotations)
+
+		changes, _ := config.mutationsFor(newRuleContext(context.Background(), pod, testRequest("jane")))
+		if got := changes.Labels.Set["environment"]; got != "production" {
+			t.Errorf("%v: mutationsFor() set environment=%q, want production", annotations, got)
+		}
+		if _, ok := changes.Labels.Set["team"]; ok || !slices.Contains(changes.Exempted, "team") {
+			t.Errorf("%v: mutationsFor() did not exempt team, exempted %v", annotations, changes.Exempted)
+		}
+
+		mutated, err := changes.apply(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if violations, _ := config.violationsFor(newRuleContext(context.Background(), mutated, testRequest("jane"))); len(violations[modeDeny]) > 0 {
+			t.Errorf("%v: violationsFor() denied the mutated pod: %v", annotations, violations[modeDeny])
+		}
+	}
+}
+
+func TestModeFor(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          EnforcementConfig
+		requirementMode string
+		namespaceLabels map[string]string
+		want            string
+	}{
+		{name: "deny by default", want: modeDeny},
+		{name: "default mode", config: EnforcementConfig{Mode: modeWarn}, want: modeWarn},
+		{name: "requirement mode overrides the default", config: EnforcementConfig{Mode: modeWarn}, requirementMode: modeAudit, want: modeAudit},
+		{name: "namespace label overrides the requirement", requirementMode: modeDeny, namespaceLabels: map[string]string{defaultEnforcementLabel: modeWarn}, want: modeWarn},
+		{name: "unsupported namespace mode is ignored", requirementMode: modeAudit, namespaceLabels: map[string]string{defaultEnforcementLabel: "Block"}, want: modeAudit},
+		{name: "custom namespace label", config: EnforcementConfig{NamespaceLabel: "example.com/mode"}, namespaceLabels: map[string]string{"example.com/mode": modeAudit}, want: modeAudit},
+		{name: "default label ignored with a custom label", config: EnforcementConfig{NamespaceLabel: "example.com/mode"}, namespaceLabels: map[string]string{defaultEnforcementLabel: modeAudit}, want: modeDeny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: tt.namespaceLabels}}
+			if got := tt.config.modeFor(&LabelRequirement{Name: "team", Mode: tt.requirementMode}, namespace); got != tt.want {
+				t.Errorf("modeFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	tests := []struct {
+		name            string
+		requiredLabels  string
+		namespaceLabels map[string]string
+		wantAllowed     bool
+		wantWarnings    int
+		wantAudit       bool
+	}{
+		{name: "satisfied", requiredLabels: "  - name: app\n", wantAllowed: true},
+		{name: "missing label is denied", requiredLabels: "  - name: team\n"},
+		{name: "value outside the allow-list is denied", requiredLabels: "  - name: app\n    values: [api]\n"},
+		{name: "pattern mismatch is denied", requiredLabels: "  - name: app\n    pattern: 'api-.*'\n"},
+		{name: "warn mode", requiredLabels: "  - name: team\n    mode: Warn\n  - name: environment\n    mode: Warn\n", wantAllowed: true, wantWarnings: 2},
+		{name: "audit mode", requiredLabels: "  - name: team\n    mode: Audit\n", wantAllowed: true, wantAudit: true},
+		{name: "mixed modes", requiredLabels: "  - name: team\n    mode: Warn\n  - name: environment\n", wantWarnings: 1},
+		{name: "namespace mode", requiredLabels: "  - name: team\n", namespaceLabels: map[string]string{defaultEnforcementLabel: modeWarn}, wantAllowed: true, wantWarnings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestNamespaces(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: tt.namespaceLabels}})
+			config, err := parseConfig([]byte("requiredLabels:\n" + tt.requiredLabels))
+			if err != nil {
+				t.Fatalf("parseConfig() error = %v", err)
+			}
+
+			ctx := newRuleContext(context.Background(), testPod(), testRequest("jane"))
+			violations, _ := config.violationsFor(ctx)
+			response := admissionv1.AdmissionResponse{Allowed: true}
+			enforce(&response, ctx, violations)
+
+			if response.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", response.Allowed, tt.wantAllowed)
+			}
+			if !response.Allowed && (response.Result == nil || response.Result.Reason != metav1.StatusReasonInvalid) {
+				t.Errorf("Result = %+v, want an Invalid status", response.Result)
+			}
+			if got := len(response.Warnings); got != tt.wantWarnings {
+				t.Errorf("Warnings = %q, want %d", response.Warnings, tt.wantWarnings)
+			}
+			if _, got := response.AuditAnnotations["violations"]; got != tt.wantAudit {
+				t.Errorf("AuditAnnotations = %v, want violations %v", response.AuditAnnotations, tt.wantAudit)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -56,6 +57,9 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var ownerKindMappings string
+	var ownerKindFallback string
+	var nodeLabelKeys string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -67,6 +71,13 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&ownerKindMappings, "owner-kind-mappings", "",
+		"Comma separated [group/]Kind=value mappings of owner kinds to owningResource label values, "+
+			"taking precedence over the built-in mappings, e.g. argoproj.io/Rollout=Deployment.")
+	flag.StringVar(&ownerKindFallback, "owner-kind-fallback", "",
+		"The owningResource label value of owner kinds without a mapping. The owner kind itself is used when empty.")
+	flag.StringVar(&nodeLabelKeys, "node-label-keys", "",
+		"Comma separated Node labels copied onto scheduled pods. The zone, region and instance type are copied when empty.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -75,6 +86,21 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	mappings, err := controller.ParseOwnerKindMappings(ownerKindMappings)
+	if err != nil {
+		setupLog.Error(err, "invalid --owner-kind-mappings")
+		os.Exit(1)
+	}
+	if mappings != nil {
+		mappings = append(mappings, controller.DefaultOwnerKindMappings...)
+	}
+	var nodeLabels []string
+	for _, key := range strings.Split(nodeLabelKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			nodeLabels = append(nodeLabels, key)
+		}
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -143,8 +169,12 @@ func main() {
 	}
 
 	if err = (&controller.PodReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		APIReader:         mgr.GetAPIReader(),
+		OwnerKindMappings: mappings,
+		OwnerKindFallback: ownerKindFallback,
+		NodeLabelKeys:     nodeLabels,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
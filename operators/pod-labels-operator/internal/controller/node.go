@@ -0,0 +1,57 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultNodeLabelKeys are the Node labels copied onto scheduled pods. They
+// match the defaults of the admission webhook.
+var DefaultNodeLabelKeys = []string{
+	corev1.LabelTopologyZone,
+	corev1.LabelTopologyRegion,
+	corev1.LabelInstanceTypeStable,
+}
+
+// nodeLabels returns the configured labels of the Node the pod is scheduled
+// on. It is empty while the pod is unscheduled.
+func (r *PodReconciler) nodeLabels(ctx context.Context, pod *corev1.Pod) (map[string]string, error) {
+	labels := map[string]string{}
+	if pod.Spec.NodeName == "" {
+		return labels, nil
+	}
+
+	var node corev1.Node
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, &node); err != nil {
+		return nil, err
+	}
+
+	keys := r.NodeLabelKeys
+	if keys == nil {
+		keys = DefaultNodeLabelKeys
+	}
+	for _, key := range keys {
+		if value, ok := node.Labels[key]; ok {
+			labels[key] = value
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// NoOwnerValue is the owningResource label value of pods without a controller
+	NoOwnerValue = "None"
+
+	// maxOwnerDepth bounds the walk up the ownership chain
+	maxOwnerDepth = 10
+	// ownerLookupTimeout bounds the walk up the ownership chain
+	ownerLookupTimeout = 5 * time.Second
+)
+
+// cachedOwnerKinds are the owner kinds the manager may watch, which are read
+// from its cache. Other owners are read from the API server, as the cache
+// would start informers that never sync for kinds the manager cannot list.
+var cachedOwnerKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: "Deployment"}:  true,
+	{Group: "apps", Kind: "ReplicaSet"}:  true,
+	{Group: "apps", Kind: "StatefulSet"}: true,
+	{Group: "apps", Kind: "DaemonSet"}:   true,
+	{Group: "batch", Kind: "CronJob"}:    true,
+	{Group: "batch", Kind: "Job"}:        true,
+}
+
+// OwnerKindMapping maps an owner kind to an owningResource label value.
+// An APIGroup of "*" matches any group.
+type OwnerKindMapping struct {
+	APIGroup string
+	Kind     string
+	Value    string
+}
+
+// DefaultOwnerKindMappings recognise the in-tree workload controllers and
+// common custom controllers. Keep them in sync with builtinOwnerMappings in
+// k8s-admission-controller/cmd/controller/owners.go, so that the webhook and
+// the operator label pods alike.
+var DefaultOwnerKindMappings = []OwnerKindMapping{
+	{APIGroup: "apps", Kind: "Deployment", Value: "Deployment"},
+	{APIGroup: "apps", Kind: "ReplicaSet", Value: "ReplicaSet"},
+	{APIGroup: "apps", Kind: "StatefulSet", Value: "StatefulSet"},
+	{APIGroup: "apps", Kind: "DaemonSet", Value: "DaemonSet"},
+	{APIGroup: "batch", Kind: "CronJob", Value: "CronJob"},
+	{APIGroup: "batch", Kind: "Job", Value: "Job"},
+	{APIGroup: "", Kind: "ReplicationController", Value: "ReplicationController"},
+	{APIGroup: "", Kind: "Node", Value: "Node"},
+	{APIGroup: "argoproj.io", Kind: "Rollout", Value: "Rollout"},
+	{APIGroup: "kubevirt.io", Kind: "VirtualMachine", Value: "VirtualMachine"},
+	{APIGroup: "kubevirt.io", Kind: "VirtualMachineInstance", Value: "VirtualMachineInstance"},
+}
+
+// owningResource returns the owningResource label value of obj. It follows
+// the controller owner references up to the top-level owner, stopping at the
+// last owner it can read, and maps its kind through the configured mappings.
+// Kinds without a mapping use OwnerKindFallback, or the kind itself when it
+// is empty.
+func (r *PodReconciler) owningResource(ctx context.Context, obj metav1.Object) string {
+	ref := metav1.GetControllerOfNoCopy(obj)
+	if ref == nil {
+		return NoOwnerValue
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ownerLookupTimeout)
+	defer cancel()
+	for depth := 1; depth < maxOwnerDepth; depth++ {
+		owner := &metav1.PartialObjectMetadata{}
+		owner.SetGroupVersionKind(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+		if err := r.ownerReader(owner.GroupVersionKind().GroupKind()).Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: ref.Name}, owner); err != nil {
+			break
+		}
+
+		next := metav1.GetControllerOfNoCopy(owner)
+		if next == nil {
+			break
+		}
+		ref = next
+	}
+
+	return r.ownerKindValue(ref)
+}
+
+// ownerReader returns the reader of the owners of kind gk
+func (r *PodReconciler) ownerReader(gk schema.GroupKind) client.Reader {
+	if cachedOwnerKinds[gk] || r.APIReader == nil {
+		return r.Client
+	}
+	return r.APIReader
+}
+
+func (r *PodReconciler) ownerKindValue(ref *metav1.OwnerReference) string {
+	group := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).Group
+
+	mappings := r.OwnerKindMappings
+	if mappings == nil {
+		mappings = DefaultOwnerKindMappings
+	}
+	for _, mapping := range mappings {
+		if (mapping.APIGroup == "*" || mapping.APIGroup == group) && mapping.Kind == ref.Kind {
+			return mapping.Value
+		}
+	}
+
+	if r.OwnerKindFallback != "" {
+		return r.OwnerKindFallback
+	}
+	return ref.Kind
+}
+
+// ParseOwnerKindMappings parses comma separated owner kind mappings of the
+// form [group/]Kind=value, e.g. argoproj.io/Rollout=Deployment. Kinds without
+// a group are in the core API group, and a group of "*" matches any group.
+func ParseOwnerKindMappings(value string) ([]OwnerKindMapping, error) {
+	var mappings []OwnerKindMapping
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, mappedValue, ok := strings.Cut(entry, "=")
+		if !ok || mappedValue == "" {
+			return nil, fmt.Errorf("invalid owner kind mapping %q: expected [group/]Kind=value", entry)
+		}
+		mapping := OwnerKindMapping{Kind: kind, Value: mappedValue}
+		if group, name, ok := strings.Cut(kind, "/"); ok {
+			mapping.APIGroup, mapping.Kind = group, name
+		}
+		if mapping.Kind == "" {
+			return nil, fmt.Errorf("invalid owner kind mapping %q: kind is required", entry)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
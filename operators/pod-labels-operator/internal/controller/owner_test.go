@@ -0,0 +1,169 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// apiReader records the kinds read from the API server, which serves none
+// of them
+type apiReader struct {
+	client.Reader
+	kinds []string
+}
+
+func (r *apiReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	r.kinds = append(r.kinds, gvk.Kind)
+	return apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}, key.Name)
+}
+
+func controllerRef(apiVersion, kind, name string) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, Controller: ptr.To(true)}
+}
+
+func TestOwningResource(t *testing.T) {
+	objects := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "team-a",
+			Name:            "web-5d8f",
+			OwnerReferences: []metav1.OwnerReference{controllerRef("apps/v1", "Deployment", "web")},
+		}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "team-a",
+			Name:            "backup-2901",
+			OwnerReferences: []metav1.OwnerReference{controllerRef("batch/v1", "CronJob", "backup")},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		owner     *metav1.OwnerReference
+		mappings  []OwnerKindMapping
+		fallback  string
+		want      string
+		wantReads []string
+	}{
+		{name: "no controller", want: NoOwnerValue},
+		{name: "ownership chain", owner: ptr.To(controllerRef("apps/v1", "ReplicaSet", "web-5d8f")), want: "Deployment"},
+		{name: "missing owner stops the walk", owner: ptr.To(controllerRef("batch/v1", "Job", "backup-2901")), want: "CronJob"},
+		{
+			name:      "uncached kind is read from the API server",
+			owner:     ptr.To(controllerRef("argoproj.io/v1alpha1", "Rollout", "web")),
+			want:      "Rollout",
+			wantReads: []string{"Rollout"},
+		},
+		{
+			name:      "configured mapping",
+			owner:     ptr.To(controllerRef("argoproj.io/v1alpha1", "Rollout", "web")),
+			mappings:  []OwnerKindMapping{{APIGroup: "argoproj.io", Kind: "Rollout", Value: "Deployment"}},
+			want:      "Deployment",
+			wantReads: []string{"Rollout"},
+		},
+		{
+			name:      "fallback",
+			owner:     ptr.To(controllerRef("example.com/v1", "Widget", "web")),
+			fallback:  "Other",
+			want:      "Other",
+			wantReads: []string{"Widget"},
+		},
+		{
+			name:      "owner kind without fallback",
+			owner:     ptr.To(controllerRef("example.com/v1", "Widget", "web")),
+			want:      "Widget",
+			wantReads: []string{"Widget"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := &apiReader{}
+			r := &PodReconciler{
+				Client:            fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(objects...).Build(),
+				APIReader:         reader,
+				OwnerKindMappings: tt.mappings,
+				OwnerKindFallback: tt.fallback,
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web-0"}}
+			if tt.owner != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*tt.owner}
+			}
+
+			if got := r.owningResource(context.Background(), pod); got != tt.want {
+				t.Errorf("owningResource() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(reader.kinds, tt.wantReads) {
+				t.Errorf("owningResource() read %v from the API server, want %v", reader.kinds, tt.wantReads)
+			}
+		})
+	}
+}
+
+func TestParseOwnerKindMappings(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []OwnerKindMapping
+		wantErr string
+	}{
+		{value: ""},
+		{
+			value: "argoproj.io/Rollout=Deployment, ReplicationController=Deployment,",
+			want: []OwnerKindMapping{
+				{APIGroup: "argoproj.io", Kind: "Rollout", Value: "Deployment"},
+				{Kind: "ReplicationController", Value: "Deployment"},
+			},
+		},
+		{value: "*/Workflow=Workflow", want: []OwnerKindMapping{{APIGroup: "*", Kind: "Workflow", Value: "Workflow"}}},
+		{value: "argoproj.io/Rollout", wantErr: "expected [group/]Kind=value"},
+		{value: "Rollout=", wantErr: "expected [group/]Kind=value"},
+		{value: "argoproj.io/=Rollout", wantErr: "kind is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseOwnerKindMappings(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseOwnerKindMappings() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOwnerKindMappings() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOwnerKindMappings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
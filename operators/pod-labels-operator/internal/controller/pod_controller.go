@@ -31,10 +31,26 @@ import (
 type PodReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// APIReader reads the owners whose kinds the manager does not cache,
+	// bypassing the cache. The cached client is used when nil.
+	APIReader client.Reader
+
+	// OwnerKindMappings maps owner kinds to owningResource label values.
+	// DefaultOwnerKindMappings are used when nil.
+	OwnerKindMappings []OwnerKindMapping
+	// OwnerKindFallback is the owningResource value of owner kinds without a
+	// mapping. The owner kind itself is used when empty.
+	OwnerKindFallback string
+	// NodeLabelKeys are the Node labels copied onto scheduled pods.
+	// DefaultNodeLabelKeys are used when nil.
+	NodeLabelKeys []string
 }
 
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=core,resources=pods/status,verbs=update;
+// +kubebuilder:rbac:groups=apps,resources=replicasets;deployments;statefulsets;daemonsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
@@ -53,16 +69,11 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	// Check if the pod already has all the required labels
 	requiredLabels := map[string]string{
 		"environment":    "production",
-		"owningResource": "None",
+		"owningResource": r.owningResource(ctx, &pod),
 		"ipAddress":      "pending",
 		"nodeName":       "pending",
 	}
 
-	// Get owning resource if exists
-	if len(pod.OwnerReferences) > 0 {
-		requiredLabels["owningResource"] = pod.OwnerReferences[0].Kind
-	}
-
 	// Update IP address if available
 	if pod.Status.PodIP != "" {
 		requiredLabels["ipAddress"] = pod.Status.PodIP
@@ -73,6 +84,16 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		requiredLabels["nodeName"] = pod.Spec.NodeName
 	}
 
+	// Copy the topology labels of the node the pod is scheduled on
+	nodeLabels, err := r.nodeLabels(ctx, &pod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "unable to fetch Node", "Node.Name", pod.Spec.NodeName)
+		return ctrl.Result{}, err
+	}
+	for key, value := range nodeLabels {
+		requiredLabels[key] = value
+	}
+
 	// Check if all required labels are present and correct
 	needsUpdate := false
 	if pod.Labels == nil {